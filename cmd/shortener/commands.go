@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/backup"
+	"github.com/advn1/url-shortener/internal/handler"
+	"github.com/advn1/url-shortener/internal/screening"
+	"go.uber.org/zap"
+)
+
+// commands run instead of the server when the first argument names one
+var commands = map[string]func(args []string, sugar *zap.SugaredLogger) error{
+	"import":          runImport,
+	"migrate-storage": runMigrateStorage,
+	"backup":          runBackup,
+	"restore":         runRestore,
+}
+
+// storageSpec is spec, or the spec of the server storage chosen by
+// DATABASE_DSN and then FILE_STORAGE_PATH when spec is empty
+func storageSpec(spec string) (string, error) {
+	if spec != "" {
+		return spec, nil
+	}
+	if dsn := strings.TrimSpace(os.Getenv("DATABASE_DSN")); dsn != "" {
+		return "postgres:" + dsn, nil
+	}
+	if path := strings.TrimSpace(os.Getenv("FILE_STORAGE_PATH")); path != "" {
+		return "file:" + path, nil
+	}
+	return "", errors.New("no storage given, and neither DATABASE_DSN nor FILE_STORAGE_PATH is set")
+}
+
+// openStorage opens the storage of a spec like file:PATH or postgres:DSN. An
+// empty spec is the storage of the server
+func openStorage(spec string, sugar *zap.SugaredLogger) (*handler.Handler, func(), error) {
+	spec, err := storageSpec(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	baseURL := strings.TrimSpace(os.Getenv("BASE_URL"))
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	kind, location, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "postgres", "postgresql":
+		// postgres://user@host/db is a DSN by itself
+		dsn := location
+		if strings.HasPrefix(location, "//") {
+			dsn = spec
+		}
+		db := initDB(dsn, sugar)
+		return handler.New(baseURL, make(map[string]string), "", db, sugar), func() { db.Close() }, nil
+	case "file":
+		if location == "" {
+			return nil, nil, errors.New("file storage needs a path, like file:/var/lib/shortener/urls.json")
+		}
+		h := handler.New(baseURL, make(map[string]string), location, nil, sugar)
+		// a missing file is an empty storage. it is created by the first write
+		if _, err := os.Stat(location); errors.Is(err, fs.ErrNotExist) {
+			return h, func() {}, nil
+		}
+		if err := h.LoadFromFile(); err != nil {
+			return nil, nil, fmt.Errorf("loading %s: %w", location, err)
+		}
+		return h, func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown storage %q, want file:PATH or postgres:DSN", spec)
+}
+
+func runImport(args []string, sugar *zap.SugaredLogger) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	to := flags.String("to", "", "storage to import into, file:PATH or postgres:DSN. the server storage from DATABASE_DSN or FILE_STORAGE_PATH when empty")
+	owner := flags.String("owner", "", "owner of the imported links")
+	format := flags.String("format", "", "csv or ndjson. guessed from the file extension when empty")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: shortener import [flags] FILE\n\nimports links from a CSV or JSON-lines file, - for stdin. rows have original_url, alias, created_at and tags")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("import needs exactly one file")
+	}
+
+	path := flags.Arg(0)
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			*format = handler.ImportCSV
+		case ".ndjson", ".jsonl", ".json":
+			*format = handler.ImportNDJSON
+		default:
+			return fmt.Errorf("cannot guess the format of %q, set -format", path)
+		}
+	}
+
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	h, closeStorage, err := openStorage(*to, sugar)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	// imported links are screened like links created over the API
+	screener, err := screening.New(strings.TrimSpace(os.Getenv("BLOCKLIST_PATH")))
+	if err != nil {
+		return err
+	}
+	h.Screener = screener
+
+	report, err := h.Import(context.Background(), input, handler.ImportOptions{Format: *format, Owner: *owner})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	sugar.Infow("Import done", "imported", report.Imported, "failed", report.Failed)
+	return nil
+}
+
+func runMigrateStorage(args []string, sugar *zap.SugaredLogger) error {
+	flags := flag.NewFlagSet("migrate-storage", flag.ContinueOnError)
+	from := flags.String("from", "", "storage to copy from, file:PATH or postgres:DSN")
+	to := flags.String("to", "", "storage to copy into, file:PATH or postgres:DSN")
+	dryRun := flags.Bool("dry-run", false, "only count what would be copied")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: shortener migrate-storage -from STORAGE -to STORAGE [-dry-run]\n\ncopies links, keys, domains, namespaces, revisions and clicks between storages. records already in the destination are skipped, so it can be run again")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *from == "" || *to == "" {
+		flags.Usage()
+		return errors.New("migrate-storage needs both -from and -to")
+	}
+	if *from == *to {
+		return errors.New("-from and -to are the same storage")
+	}
+
+	src, closeSrc, err := openStorage(*from, sugar)
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+	dst, closeDst, err := openStorage(*to, sugar)
+	if err != nil {
+		return err
+	}
+	defer closeDst()
+
+	verb := "copied"
+	if *dryRun {
+		verb = "to copy"
+	}
+	report, err := src.MigrateTo(context.Background(), dst, handler.MigrateOptions{DryRun: *dryRun, Progress: migrateProgress(verb)})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// migrateProgress prints the progress of MigrateTo to stderr
+func migrateProgress(verb string) func(stage string, count handler.MigrateCount) {
+	return func(stage string, count handler.MigrateCount) {
+		fmt.Fprintf(os.Stderr, "%s: %d %s, %d skipped, %d conflicts\n", stage, count.Copied, verb, count.Skipped, count.Conflicts)
+	}
+}
+
+// backupCounts are the records per kind a backup holds
+func backupCounts(report *handler.MigrateReport) map[string]int {
+	return map[string]int{
+		"domains":    report.Domains.Copied,
+		"namespaces": report.Namespaces.Copied,
+		"api_keys":   report.APIKeys.Copied,
+		"links":      report.Links.Copied,
+		"revisions":  report.Revisions.Copied,
+		"clicks":     report.Clicks.Copied,
+	}
+}
+
+func runBackup(args []string, sugar *zap.SugaredLogger) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	from := flags.String("from", "", "storage to back up, file:PATH or postgres:DSN. the server storage from DATABASE_DSN or FILE_STORAGE_PATH when empty")
+	output := flags.String("o", "", "file to write the backup to. shortener-TIME.tar.gz when empty, - for stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: shortener backup [-from STORAGE] [-o FILE]\n\nwrites a compressed snapshot of links, owners, keys, domains, namespaces, revisions and clicks, with a manifest and a checksum. restore it with shortener restore. every backup is a full snapshot, changes made after it are not recorded")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	spec, err := storageSpec(*from)
+	if err != nil {
+		return err
+	}
+	createdAt := time.Now().UTC()
+	if *output == "" {
+		*output = "shortener-" + createdAt.Format("20060102-150405") + ".tar.gz"
+	}
+
+	src, closeSrc, err := openStorage(spec, sugar)
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	// the records are first copied to a storage file, which is what a backup holds
+	tmpDir, err := os.MkdirTemp("", "shortener-backup")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	dataPath := filepath.Join(tmpDir, "data.jsonl")
+	data := handler.New(src.BaseURL, make(map[string]string), dataPath, nil, sugar)
+
+	ctx := context.Background()
+	snapshot, closeSnapshot, err := src.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	report, err := snapshot.MigrateTo(ctx, data, handler.MigrateOptions{Progress: migrateProgress("copied")})
+	closeSnapshot()
+	if err != nil {
+		return err
+	}
+
+	dataFile, err := os.OpenFile(dataPath, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+
+	kind, _, _ := strings.Cut(spec, ":")
+	manifest := &backup.Manifest{CreatedAt: createdAt, Source: kind, Counts: backupCounts(report)}
+	if *output == "-" {
+		if err := backup.Write(os.Stdout, manifest, dataFile); err != nil {
+			return err
+		}
+	} else {
+		// written next to the output and renamed, so a failed backup leaves no partial file
+		out, err := os.CreateTemp(filepath.Dir(*output), ".shortener-backup-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(out.Name())
+		if err := backup.Write(out, manifest, dataFile); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(out.Name(), *output); err != nil {
+			return err
+		}
+	}
+
+	sugar.Infow("Backup done", "output", *output, "sha256", manifest.SHA256, "links", report.Links.Copied, "clicks", report.Clicks.Copied)
+	return nil
+}
+
+func runRestore(args []string, sugar *zap.SugaredLogger) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	to := flags.String("to", "", "storage to restore into, file:PATH or postgres:DSN. the server storage from DATABASE_DSN or FILE_STORAGE_PATH when empty")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: shortener restore [-to STORAGE] FILE\n\nrestores a backup, - for stdin, into an empty storage of any backend after verifying its checksum. a backup is a full snapshot taken when it was written, so the storage comes back as it was then: there is no point-in-time recovery to moments between backups")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("restore needs exactly one backup file")
+	}
+
+	var input io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	ctx := context.Background()
+	dst, closeDst, err := openStorage(*to, sugar)
+	if err != nil {
+		return err
+	}
+	defer closeDst()
+	empty, err := dst.Empty(ctx)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return errors.New("restore needs an empty storage, the destination already has records")
+	}
+
+	// the records are verified before any of them is restored
+	tmpDir, err := os.MkdirTemp("", "shortener-restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	dataPath := filepath.Join(tmpDir, "data.jsonl")
+	dataFile, err := os.Create(dataPath)
+	if err != nil {
+		return err
+	}
+	manifest, err := backup.Read(input, dataFile)
+	if closeErr := dataFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	data := handler.New(dst.BaseURL, make(map[string]string), dataPath, nil, sugar)
+	if err := data.LoadFromFile(); err != nil {
+		return fmt.Errorf("loading backup records: %w", err)
+	}
+	report, err := data.MigrateTo(ctx, dst, handler.MigrateOptions{Progress: migrateProgress("restored")})
+	if err != nil {
+		return err
+	}
+	for kind, want := range manifest.Counts {
+		if got := backupCounts(report)[kind]; got != want {
+			return fmt.Errorf("restored %d %s, the backup has %d", got, kind, want)
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	sugar.Infow("Restore done", "created_at", manifest.CreatedAt, "source", manifest.Source, "links", report.Links.Copied)
+	return nil
+}
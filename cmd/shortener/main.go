@@ -1,16 +1,17 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"database/sql"
-	"encoding/json"
 	"net/http"
 	"os"
 
 	"github.com/advn1/url-shortener/internal/config"
+	"github.com/advn1/url-shortener/internal/geoip"
 	"github.com/advn1/url-shortener/internal/handler"
 	"github.com/advn1/url-shortener/internal/middleware"
+	"github.com/advn1/url-shortener/internal/screening"
+	"github.com/advn1/url-shortener/internal/validator"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"go.uber.org/zap"
 )
@@ -26,6 +27,16 @@ func main() {
 	// logger wrapper. provides more ergonomic API
 	sugar := logger.Sugar()
 
+	// maintenance commands like "shortener import links.csv"
+	if len(os.Args) > 1 {
+		if command, exists := commands[os.Args[1]]; exists {
+			if err := command(os.Args[2:], sugar); err != nil {
+				sugar.Fatalw("Command error", "command", os.Args[1], "error", err)
+			}
+			return
+		}
+	}
+
 	// parse application config and validate it
 	cfg := config.Parse()
 	if err := cfg.Validate(); err != nil {
@@ -42,7 +53,6 @@ func main() {
 		defer db.Close()
 	} else if cfg.FileStoragePath != "" {
 		sugar.Infow("Storage mode: File")
-		urlsMap = initUrlsMap(cfg.FileStoragePath, sugar)
 	} else {
 		sugar.Infow("Storage mode: In-memory")
 		// nothing happens. urlsMap is already initialized
@@ -50,16 +60,84 @@ func main() {
 
 	// init handler and mux
 	h := handler.New(cfg.BaseURL, urlsMap, cfg.FileStoragePath, db, sugar)
+	h.AdminToken = cfg.AdminToken
+	h.URLPolicy = &validator.Policy{
+		AllowedSchemes: cfg.AllowedURLSchemes,
+		MaxLength:      int(cfg.MaxURLLength),
+		StripFragment:  cfg.StripURLFragment,
+		SortQuery:      cfg.SortURLQuery,
+	}
+	h.DefaultRedirectType = int(cfg.DefaultRedirectType)
+	h.PermanentRedirectMaxAge = cfg.PermanentRedirectMaxAge
+	h.ComingSoonURL = cfg.ComingSoonURL
+	h.TrustedProxies = cfg.TrustedProxies
+	h.DefaultPageSize = int(cfg.DefaultPageSize)
+	h.MaxPageSize = int(cfg.MaxPageSize)
+	h.DomainCacheTTL = cfg.DomainCacheTTL
+	if cfg.CursorSecret != "" {
+		h.CursorSecret = []byte(cfg.CursorSecret)
+	}
+	if db == nil && cfg.FileStoragePath != "" {
+		if err := h.LoadFromFile(); err != nil {
+			sugar.Fatalw("Loading file error", "error", err)
+		}
+	}
+
+	// load blocklist and keep it fresh
+	screener, err := screening.New(cfg.BlocklistPath)
+	if err != nil {
+		sugar.Fatalw("Loading blocklist error", "error", err)
+	}
+	h.Screener = screener
+	go screener.Watch(context.Background(), cfg.BlocklistReloadInterval, func(err error) {
+		if err != nil {
+			sugar.Errorw("Reloading blocklist error", "error", err)
+			return
+		}
+		sugar.Infow("Blocklist reloaded", "path", cfg.BlocklistPath)
+	})
+
+	// geo-targeted links need a GeoIP database
+	locator, err := geoip.Open(cfg.GeoIPDatabasePath)
+	if err != nil {
+		sugar.Fatalw("Loading GeoIP database error", "error", err)
+	}
+	if locator != nil {
+		defer locator.Close()
+		h.GeoIP = locator
+	}
+
 	mux := http.NewServeMux()
 
 	// register endpoints
 	mux.HandleFunc("/", h.HandlePost)
 	mux.HandleFunc("/{id}", h.HandleGetById)
+	// namespaced links. /{team}/{code}/qr would conflict with /api/urls/{id}, so
+	// their QR codes are served by /{id}/qr with the slash escaped as %2F
+	mux.HandleFunc("/{team}/{code}", h.HandleGetById)
+	mux.HandleFunc("/{id}/qr", h.HandleQR)
 	mux.HandleFunc("/api/shorten", h.HandlePostRESTApi)
 	mux.HandleFunc("/ping", h.PingBD)
+	mux.HandleFunc("/api/admin/keys", h.HandleAPIKeys)
+	mux.HandleFunc("/api/admin/keys/{id}", h.HandleAPIKeyById)
+	mux.HandleFunc("/api/admin/flagged", h.HandleFlaggedLinks)
+	mux.HandleFunc("/api/admin/domains", h.HandleDomains)
+	mux.HandleFunc("/api/urls/{id}", h.HandleURLById)
+	mux.HandleFunc("/api/urls/{id}/history", h.HandleURLHistory)
+	mux.HandleFunc("/api/user/urls", h.HandleUserURLs)
+	mux.HandleFunc("/api/import", h.HandleImport)
+	mux.HandleFunc("/api/export", h.HandleExport)
+	mux.HandleFunc("/api/namespaces", h.HandleNamespaces)
+	mux.HandleFunc("/api/namespaces/{name}", h.HandleNamespaceByName)
 	
 	// create a middlewared-handler
-	handler := middleware.GzipMiddleware(middleware.LoggingMiddleware(mux, sugar))
+	// body limits wrap the decompression from both sides: wire size first, then decoded size.
+	// bulk imports stream their body, so they get a limit of their own
+	importLimit := map[string]int64{"/api/import": cfg.MaxImportBodySize}
+	handler := middleware.LoggingMiddleware(mux, sugar)
+	handler = middleware.RouteBodyLimitMiddleware(handler, cfg.MaxDecompressedBodySize, importLimit)
+	handler = middleware.CompressMiddleware(handler)
+	handler = middleware.RouteBodyLimitMiddleware(handler, cfg.MaxBodySize, importLimit)
 
 	// start listening
 	sugar.Infow("Starting server", "address", cfg.ServerAddr, "base URL", cfg.BaseURL)
@@ -69,38 +147,6 @@ func main() {
 	}
 }
 
-func loadFromFile(filename string) (map[string]string, error) {
-	file, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0664)
-	if err != nil {
-		return map[string]string{}, err
-	}
-
-	scanner := bufio.NewScanner(file)
-	urlsMap := make(map[string]string, 10)
-	
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		var jsonLine handler.PostURLResponse 
-		
-		err := json.Unmarshal(line, &jsonLine)
-		if err != nil {
-			return map[string]string{}, err
-		}
-		
-		urlsMap[jsonLine.ShortUrl] = jsonLine.OriginalUrl
-	}
-
-	return urlsMap, nil
-}
-
-func initUrlsMap(fileStoragePath string, sugar *zap.SugaredLogger) map[string]string {
-	urlsMap, err := loadFromFile(fileStoragePath)
-	if err != nil {
-		sugar.Fatalw("Loading file error", "error", err)
-	}	
-	return urlsMap
-}
-
 func initDB(dsn string, sugar *zap.SugaredLogger) *sql.DB {
 	// init db
 	db, err := sql.Open("pgx", dsn)
@@ -113,14 +159,136 @@ func initDB(dsn string, sugar *zap.SugaredLogger) *sql.DB {
         sugar.Fatalw("cannot ping db", "error", err)
 	}
 
-	// create table
-	_, err = db.ExecContext(context.Background(), `CREATE TABLE IF NOT EXISTS urls (
+	// create tables
+	for _, query := range schema {
+		if _, err = db.ExecContext(context.Background(), query); err != nil {
+			sugar.Fatalw("cannot init db table", "error", err)
+		}
+	}
+	for _, optional := range optionalSchema {
+		for _, query := range optional.statements {
+			if _, err = db.ExecContext(context.Background(), query); err != nil {
+				sugar.Warnw("Skipping optional schema", "purpose", optional.purpose, "hint", optional.hint, "error", err)
+				break
+			}
+		}
+	}
+	return db
+}
+
+// schema statements are run in order on every start, so each of them must be idempotent
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS urls (
 	id CHAR(36) PRIMARY KEY,
 	original_url VARCHAR(100) NOT NULL,
 	short_url VARCHAR(100) NOT NULL UNIQUE
-	)`)
-	if err != nil {
-		sugar.Fatalw("cannot init db table", "error", err)
-	}	
-	return db
-}
\ No newline at end of file
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+	id CHAR(36) PRIMARY KEY,
+	owner VARCHAR(100) NOT NULL,
+	key_hash CHAR(64) NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	revoked_at TIMESTAMPTZ
+	)`,
+	`ALTER TABLE urls
+	ADD COLUMN IF NOT EXISTS api_key_id CHAR(36) REFERENCES api_keys (id),
+	ADD COLUMN IF NOT EXISTS owner VARCHAR(100)`,
+	// URL length is limited by the validator policy, not by the column
+	`ALTER TABLE urls ALTER COLUMN original_url TYPE TEXT`,
+	`ALTER TABLE urls
+	ADD COLUMN IF NOT EXISTS flagged BOOLEAN NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS flag_reason TEXT`,
+	// 0 means the server default
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_type SMALLINT NOT NULL DEFAULT 0`,
+	// previous destinations of edited links
+	`CREATE TABLE IF NOT EXISTS url_revisions (
+	id BIGSERIAL PRIMARY KEY,
+	short_url VARCHAR(100) NOT NULL,
+	original_url TEXT NOT NULL,
+	replaced_at TIMESTAMPTZ NOT NULL,
+	replaced_by VARCHAR(100)
+	)`,
+	`CREATE INDEX IF NOT EXISTS url_revisions_short_url_idx ON url_revisions (short_url)`,
+	// clicks is a counter kept next to the link so reading it stays cheap
+	`ALTER TABLE urls
+	ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	ADD COLUMN IF NOT EXISTS clicks BIGINT NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS clicks (
+	id BIGSERIAL PRIMARY KEY,
+	short_url VARCHAR(100) NOT NULL,
+	clicked_at TIMESTAMPTZ NOT NULL,
+	referer TEXT,
+	user_agent TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS clicks_short_url_idx ON clicks (short_url, clicked_at)`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash TEXT`,
+	// NULL means unlimited
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_clicks BIGINT`,
+	// activation window, open on either side when NULL
+	`ALTER TABLE urls
+	ADD COLUMN IF NOT EXISTS not_before TIMESTAMPTZ,
+	ADD COLUMN IF NOT EXISTS not_after TIMESTAMPTZ`,
+	// {"ios": "...", "android": "..."} destination overrides
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS platform_urls JSONB`,
+	// [{"url": "...", "weight": 1}] traffic split
+	`ALTER TABLE urls
+	ADD COLUMN IF NOT EXISTS destinations JSONB,
+	ADD COLUMN IF NOT EXISTS sticky BOOLEAN NOT NULL DEFAULT false`,
+	`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS destination TEXT`,
+	// {"DE": "...", "FR": "..."} destination overrides
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS country_urls JSONB`,
+	`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS country CHAR(2)`,
+	`ALTER TABLE urls
+	ADD COLUMN IF NOT EXISTS pass_query BOOLEAN NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS utm_source TEXT,
+	ADD COLUMN IF NOT EXISTS utm_medium TEXT,
+	ADD COLUMN IF NOT EXISTS utm_campaign TEXT`,
+	// vanity domains. links on the default domain have an empty domain, and
+	// short codes are unique per domain instead of globally
+	`CREATE TABLE IF NOT EXISTS domains (
+	name VARCHAR(253) PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE urls ADD COLUMN IF NOT EXISTS domain VARCHAR(253) NOT NULL DEFAULT ''`,
+	`ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_short_url_key`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS urls_domain_short_url_idx ON urls (domain, short_url)`,
+	`ALTER TABLE url_revisions ADD COLUMN IF NOT EXISTS domain VARCHAR(253) NOT NULL DEFAULT ''`,
+	`ALTER TABLE clicks ADD COLUMN IF NOT EXISTS domain VARCHAR(253) NOT NULL DEFAULT ''`,
+	// first segment of /{team}/{code} links. members is a comma-separated list of owners
+	`CREATE TABLE IF NOT EXISTS namespaces (
+	name VARCHAR(32) PRIMARY KEY,
+	owner VARCHAR(100) NOT NULL,
+	members TEXT NOT NULL DEFAULT '',
+	alias_pattern TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	// ["tag", ...] labels and a folder path
+	`ALTER TABLE urls
+	ADD COLUMN IF NOT EXISTS tags JSONB,
+	ADD COLUMN IF NOT EXISTS folder TEXT`,
+	`CREATE INDEX IF NOT EXISTS urls_tags_idx ON urls USING GIN (tags)`,
+	// listings are paged newest first
+	`CREATE INDEX IF NOT EXISTS urls_owner_created_at_idx ON urls (owner, created_at DESC, id DESC)`,
+	`CREATE INDEX IF NOT EXISTS urls_owner_clicks_idx ON urls (owner, clicks DESC, created_at DESC, id DESC)`,
+}
+
+// optionalSchema speeds things up but may need privileges the role of the DSN
+// lacks. A group stops at its first failing statement, which is logged, and
+// the server runs without it
+var optionalSchema = []struct {
+	purpose    string
+	hint       string
+	statements []string
+}{
+	{
+		// substring search with ILIKE works without them, by scanning the links of the owner
+		purpose: "trigram indexes for link search",
+		hint:    "have a superuser run CREATE EXTENSION pg_trgm in the database, the indexes are created on the next start",
+		statements: []string{
+			`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+			`CREATE INDEX IF NOT EXISTS urls_short_url_trgm_idx ON urls USING GIN (short_url gin_trgm_ops)`,
+			`CREATE INDEX IF NOT EXISTS urls_original_url_trgm_idx ON urls USING GIN (original_url gin_trgm_ops)`,
+		},
+	},
+}
@@ -0,0 +1,126 @@
+// Package backup reads and writes snapshots of the shortener storage. A
+// snapshot is a gzip compressed tar archive of a manifest and of the records,
+// which are lines of the storage file format
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	Format  = "shortener-backup"
+	Version = 1
+
+	manifestName = "manifest.json"
+	dataName     = "data.jsonl"
+)
+
+var ErrChecksum = errors.New("backup data doesn't match the checksum of its manifest")
+
+// Manifest describes a snapshot. Write fills in the format, the version, the
+// size and the checksum
+type Manifest struct {
+	Format    string         `json:"format"`
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	Source    string         `json:"source,omitempty"` // storage backend the snapshot was taken of
+	Counts    map[string]int `json:"counts,omitempty"` // records per kind
+	Size      int64          `json:"size"`
+	SHA256    string         `json:"sha256"` // hex digest of the records
+}
+
+// Write writes a snapshot of the records in data to w. data is read twice,
+// once for the checksum and once for the archive
+func Write(w io.Writer, manifest *Manifest, data io.ReadSeeker) error {
+	hash := sha256.New()
+	size, err := io.Copy(hash, data)
+	if err != nil {
+		return err
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	manifest.Format = Format
+	manifest.Version = Version
+	manifest.Size = size
+	manifest.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	jsonManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	compressed, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewWriter(compressed)
+
+	modTime := manifest.CreatedAt.UTC()
+	if err := archive.WriteHeader(&tar.Header{Name: manifestName, Mode: 0644, Size: int64(len(jsonManifest)), ModTime: modTime}); err != nil {
+		return err
+	}
+	if _, err := archive.Write(jsonManifest); err != nil {
+		return err
+	}
+	if err := archive.WriteHeader(&tar.Header{Name: dataName, Mode: 0644, Size: size, ModTime: modTime}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(archive, data); err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
+
+// Read copies the records of the snapshot in r to data and returns its
+// manifest. A snapshot whose records don't match the checksum fails with
+// ErrChecksum, after its records were written to data
+func Read(r io.Reader, data io.Writer) (*Manifest, error) {
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup: %w", err)
+	}
+	defer compressed.Close()
+	archive := tar.NewReader(compressed)
+
+	header, err := archive.Next()
+	if err != nil || header.Name != manifestName {
+		return nil, errors.New("not a backup: the archive doesn't start with a manifest")
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(archive).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	if manifest.Format != Format {
+		return nil, fmt.Errorf("not a backup: format is %q", manifest.Format)
+	}
+	if manifest.Version < 1 || manifest.Version > Version {
+		return nil, fmt.Errorf("backup version %d is not supported, this build reads up to version %d", manifest.Version, Version)
+	}
+
+	header, err = archive.Next()
+	if err != nil || header.Name != dataName {
+		return nil, errors.New("backup has no records")
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(data, hash), archive)
+	if err != nil {
+		return nil, err
+	}
+	if size != manifest.Size || hex.EncodeToString(hash.Sum(nil)) != manifest.SHA256 {
+		return &manifest, ErrChecksum
+	}
+	return &manifest, nil
+}
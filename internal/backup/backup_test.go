@@ -0,0 +1,96 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const records = `{"kind":"api_key","key":"k"}
+{"kind":"link","short_url":"abc"}
+`
+
+func TestWriteRead_RoundTrip(t *testing.T) {
+	var archive bytes.Buffer
+	manifest := &Manifest{CreatedAt: time.Now(), Source: "file", Counts: map[string]int{"links": 1}}
+	if err := Write(&archive, manifest, strings.NewReader(records)); err != nil {
+		t.Fatalf("error on writing backup: %v", err)
+	}
+
+	var data bytes.Buffer
+	read, err := Read(bytes.NewReader(archive.Bytes()), &data)
+	if err != nil {
+		t.Fatalf("error on reading backup: %v", err)
+	}
+	if data.String() != records {
+		t.Errorf("incorrect records %q", data.String())
+	}
+	if read.Version != Version || read.Source != "file" || read.Counts["links"] != 1 || read.SHA256 != manifest.SHA256 {
+		t.Errorf("incorrect manifest %+v", read)
+	}
+}
+
+func TestRead_Corrupted(t *testing.T) {
+	var archive bytes.Buffer
+	manifest := &Manifest{CreatedAt: time.Now()}
+	if err := Write(&archive, manifest, strings.NewReader(records)); err != nil {
+		t.Fatalf("error on writing backup: %v", err)
+	}
+
+	// other records of the same size under the manifest
+	tampered := strings.Replace(records, "abc", "xyz", 1)
+	manifest.Size = int64(len(tampered))
+	var forged bytes.Buffer
+	if err := writeWithManifest(&forged, manifest, tampered); err != nil {
+		t.Fatalf("error on writing backup: %v", err)
+	}
+	if _, err := Read(&forged, &bytes.Buffer{}); !errors.Is(err, ErrChecksum) {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+
+	if _, err := Read(strings.NewReader("plain text"), &bytes.Buffer{}); err == nil {
+		t.Errorf("expected error on reading a non-backup")
+	}
+}
+
+func TestRead_NewerVersion(t *testing.T) {
+	var archive bytes.Buffer
+	manifest := &Manifest{Format: Format, Version: Version + 1}
+	if err := writeWithManifest(&archive, manifest, records); err != nil {
+		t.Fatalf("error on writing backup: %v", err)
+	}
+	if _, err := Read(&archive, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("expected unsupported version error, got %v", err)
+	}
+}
+
+// writeWithManifest writes an archive of records under manifest as is
+func writeWithManifest(w io.Writer, manifest *Manifest, records string) error {
+	jsonManifest, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+	for _, file := range []struct {
+		name string
+		body []byte
+	}{{manifestName, jsonManifest}, {dataName, []byte(records)}} {
+		if err := archive.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.body))}); err != nil {
+			return err
+		}
+		if _, err := archive.Write(file.body); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
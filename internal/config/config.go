@@ -4,8 +4,11 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net/netip"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -13,6 +16,28 @@ type Config struct {
 	BaseURL string
 	FileStoragePath string
 	DatabaseDSN string
+	AdminToken string
+	MaxBodySize int64
+	MaxDecompressedBodySize int64
+	MaxImportBodySize int64
+	AllowedURLSchemes []string
+	MaxURLLength int64
+	StripURLFragment bool
+	SortURLQuery bool
+	BlocklistPath string
+	BlocklistReloadInterval time.Duration
+	DefaultRedirectType int64
+	PermanentRedirectMaxAge time.Duration
+	ComingSoonURL string
+	GeoIPDatabasePath string
+	TrustedProxies []netip.Prefix
+	DefaultPageSize int64
+	MaxPageSize int64
+	CursorSecret string
+	DomainCacheTTL time.Duration
+
+	// errors of options that couldn't be parsed. reported by Validate
+	parseErrs []error
 }
 
 func setValue(envValue string, flagValue string, defaultValue string) string {
@@ -25,18 +50,139 @@ func setValue(envValue string, flagValue string, defaultValue string) string {
 	return strings.TrimSpace(defaultValue)
 }
 
+// setInt64Value is setValue for numeric options. name is used in the error message
+func (c *Config) setInt64Value(name string, envValue string, flagValue string, defaultValue int64) int64 {
+	value := setValue(envValue, flagValue, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		c.parseErrs = append(c.parseErrs, fmt.Errorf("%s must be an integer, got %q", name, value))
+		return defaultValue
+	}
+	return parsed
+}
+
+// setBoolValue is setValue for boolean options. name is used in the error message
+func (c *Config) setBoolValue(name string, envValue string, flagValue string, defaultValue bool) bool {
+	value := setValue(envValue, flagValue, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		c.parseErrs = append(c.parseErrs, fmt.Errorf("%s must be a boolean, got %q", name, value))
+		return defaultValue
+	}
+	return parsed
+}
+
+// setDurationValue is setValue for durations like "30s". name is used in the error message
+func (c *Config) setDurationValue(name string, envValue string, flagValue string, defaultValue time.Duration) time.Duration {
+	value := setValue(envValue, flagValue, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		c.parseErrs = append(c.parseErrs, fmt.Errorf("%s must be a duration, got %q", name, value))
+		return defaultValue
+	}
+	return parsed
+}
+
+// setPrefixListValue is setListValue for IP addresses and CIDRs. name is used in the error message
+func (c *Config) setPrefixListValue(name string, envValue string, flagValue string, defaultValue []netip.Prefix) []netip.Prefix {
+	list := setListValue(envValue, flagValue, nil)
+	if list == nil {
+		return defaultValue
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, item := range list {
+		if prefix, err := netip.ParsePrefix(item); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			c.parseErrs = append(c.parseErrs, fmt.Errorf("%s must be IP addresses or CIDRs, got %q", name, item))
+			continue
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes
+}
+
+// setListValue is setValue for comma separated options
+func setListValue(envValue string, flagValue string, defaultValue []string) []string {
+	value := setValue(envValue, flagValue, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func Parse() *Config {
 	cfg := &Config{
 		ServerAddr:      "localhost:8080",
 		BaseURL:         "http://localhost:8080",
 		FileStoragePath: "",
 		DatabaseDSN:     "", // host=localhost user=postgres password=1234 dbname=postgres sslmode=disable
+		MaxBodySize:             1 << 20,  // 1 MiB as sent over the wire
+		MaxDecompressedBodySize: 10 << 20, // 10 MiB after Content-Encoding is undone
+		MaxImportBodySize:       100 << 20, // 100 MiB for /api/import, both sent and decompressed
+		AllowedURLSchemes:       []string{"http", "https"},
+		MaxURLLength:            2048,
+		StripURLFragment:        false,
+		SortURLQuery:            false,
+		BlocklistPath:           "",
+		BlocklistReloadInterval: 30 * time.Second,
+		DefaultRedirectType:     307,
+		PermanentRedirectMaxAge: 24 * time.Hour,
+		ComingSoonURL:           "",
+		GeoIPDatabasePath:       "",
+		TrustedProxies:          nil,
+		DefaultPageSize:         50,
+		MaxPageSize:             100,
+		CursorSecret:            "",
+		DomainCacheTTL:          time.Minute,
 	}
 
 	envServerAddr := strings.TrimSpace(os.Getenv("SERVER_ADDRESS"))
 	envBaseURL := strings.TrimSpace(os.Getenv("BASE_URL"))
 	envFileStoragePath := strings.TrimSpace(os.Getenv("FILE_STORAGE_PATH"))
 	envDatabaseDSN := strings.TrimSpace(os.Getenv("DATABASE_DSN"))
+	envAdminToken := strings.TrimSpace(os.Getenv("ADMIN_TOKEN"))
+	envMaxBodySize := strings.TrimSpace(os.Getenv("MAX_BODY_SIZE"))
+	envMaxDecompressedBodySize := strings.TrimSpace(os.Getenv("MAX_DECOMPRESSED_BODY_SIZE"))
+	envMaxImportBodySize := strings.TrimSpace(os.Getenv("MAX_IMPORT_BODY_SIZE"))
+	envAllowedURLSchemes := strings.TrimSpace(os.Getenv("ALLOWED_URL_SCHEMES"))
+	envMaxURLLength := strings.TrimSpace(os.Getenv("MAX_URL_LENGTH"))
+	envStripURLFragment := strings.TrimSpace(os.Getenv("STRIP_URL_FRAGMENT"))
+	envSortURLQuery := strings.TrimSpace(os.Getenv("SORT_URL_QUERY"))
+	envBlocklistPath := strings.TrimSpace(os.Getenv("BLOCKLIST_PATH"))
+	envBlocklistReloadInterval := strings.TrimSpace(os.Getenv("BLOCKLIST_RELOAD_INTERVAL"))
+	envDefaultRedirectType := strings.TrimSpace(os.Getenv("DEFAULT_REDIRECT_TYPE"))
+	envPermanentRedirectMaxAge := strings.TrimSpace(os.Getenv("PERMANENT_REDIRECT_MAX_AGE"))
+	envComingSoonURL := strings.TrimSpace(os.Getenv("COMING_SOON_URL"))
+	envGeoIPDatabasePath := strings.TrimSpace(os.Getenv("GEOIP_DATABASE_PATH"))
+	envTrustedProxies := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES"))
+	envDefaultPageSize := strings.TrimSpace(os.Getenv("DEFAULT_PAGE_SIZE"))
+	envMaxPageSize := strings.TrimSpace(os.Getenv("MAX_PAGE_SIZE"))
+	envCursorSecret := strings.TrimSpace(os.Getenv("CURSOR_SECRET"))
+	envDomainCacheTTL := strings.TrimSpace(os.Getenv("DOMAIN_CACHE_TTL"))
 	
 	flagServerAddr := flag.String("a", "", "HTTP server address (overridden by SERVER_ADDRESS env)")
 	flag.StringVar(flagServerAddr, "address", "", "HTTP server address (overridden by SERVER_ADDRESS env)")
@@ -46,6 +192,25 @@ func Parse() *Config {
 	flag.StringVar(flagFileStoragePath, "file", "", "path of storage file of shortened URLs (overridden by FILE_STORAGE_PATH env)")
 	flagDatabaseDSN := flag.String("d", "", "database dsn (data source name). stores all connection details (overridden by DATABASE_DSN env)")
 	flag.StringVar(flagDatabaseDSN, "database", "", "database dsn (data source name). stores all connection details (overridden by DATABASE_DSN env)")
+	flagAdminToken := flag.String("admin-token", "", "bearer token for the admin API. admin API is disabled when empty (overridden by ADMIN_TOKEN env)")
+	flagMaxBodySize := flag.String("max-body-size", "", "max request body size in bytes, as sent by the client (overridden by MAX_BODY_SIZE env)")
+	flagMaxDecompressedBodySize := flag.String("max-decompressed-body-size", "", "max request body size in bytes after decompression (overridden by MAX_DECOMPRESSED_BODY_SIZE env)")
+	flagMaxImportBodySize := flag.String("max-import-body-size", "", "max body size in bytes of bulk imports to /api/import, both as sent and after decompression. imports are streamed, so it doesn't bound memory use (overridden by MAX_IMPORT_BODY_SIZE env)")
+	flagAllowedURLSchemes := flag.String("allowed-schemes", "", "comma separated URL schemes that can be shortened (overridden by ALLOWED_URL_SCHEMES env)")
+	flagMaxURLLength := flag.String("max-url-length", "", "max length of a URL that can be shortened (overridden by MAX_URL_LENGTH env)")
+	flagStripURLFragment := flag.String("strip-fragment", "", "remove #fragment from shortened URLs (overridden by STRIP_URL_FRAGMENT env)")
+	flagSortURLQuery := flag.String("sort-query", "", "sort query parameters of shortened URLs by name. breaks destinations that depend on the order, like signed URLs (overridden by SORT_URL_QUERY env)")
+	flagBlocklistPath := flag.String("blocklist", "", "path of a file with blocked domains, IPs and CIDRs, one per line (overridden by BLOCKLIST_PATH env)")
+	flagBlocklistReloadInterval := flag.String("blocklist-reload-interval", "", "how often the blocklist file is checked for changes. 0 disables reloading (overridden by BLOCKLIST_RELOAD_INTERVAL env)")
+	flagDefaultRedirectType := flag.String("redirect-type", "", "redirect status (301, 302, 307 or 308) of links created without one (overridden by DEFAULT_REDIRECT_TYPE env)")
+	flagPermanentRedirectMaxAge := flag.String("permanent-redirect-max-age", "", "how long browsers and CDNs may cache 301 and 308 redirects (overridden by PERMANENT_REDIRECT_MAX_AGE env)")
+	flagComingSoonURL := flag.String("coming-soon-url", "", "where links outside of their activation window redirect to. 404 when empty (overridden by COMING_SOON_URL env)")
+	flagGeoIPDatabasePath := flag.String("geoip-db", "", "path of a MaxMind .mmdb country or city database for geo-targeted links (overridden by GEOIP_DATABASE_PATH env)")
+	flagTrustedProxies := flag.String("trusted-proxies", "", "comma separated IPs and CIDRs of proxies whose X-Forwarded-For and X-Real-IP headers are trusted (overridden by TRUSTED_PROXIES env)")
+	flagDefaultPageSize := flag.String("page-size", "", "links per page of listings without a limit parameter (overridden by DEFAULT_PAGE_SIZE env)")
+	flagMaxPageSize := flag.String("max-page-size", "", "max links per page of listings (overridden by MAX_PAGE_SIZE env)")
+	flagCursorSecret := flag.String("cursor-secret", "", "key that signs pagination cursors. random on every start when empty (overridden by CURSOR_SECRET env)")
+	flagDomainCacheTTL := flag.String("domain-cache-ttl", "", "how long registered domains are cached before domains registered by other instances are seen. 0 disables caching (overridden by DOMAIN_CACHE_TTL env)")
 	
 	flag.Parse()
 
@@ -53,12 +218,32 @@ func Parse() *Config {
 	cfg.BaseURL = setValue(envBaseURL, *flagBaseURL, cfg.BaseURL)
 	cfg.FileStoragePath = setValue(envFileStoragePath, *flagFileStoragePath, cfg.FileStoragePath)
 	cfg.DatabaseDSN = setValue(envDatabaseDSN, *flagDatabaseDSN, cfg.DatabaseDSN)
+	cfg.AdminToken = setValue(envAdminToken, *flagAdminToken, cfg.AdminToken)
+	cfg.MaxBodySize = cfg.setInt64Value("max body size", envMaxBodySize, *flagMaxBodySize, cfg.MaxBodySize)
+	cfg.MaxDecompressedBodySize = cfg.setInt64Value("max decompressed body size", envMaxDecompressedBodySize, *flagMaxDecompressedBodySize, cfg.MaxDecompressedBodySize)
+	cfg.MaxImportBodySize = cfg.setInt64Value("max import body size", envMaxImportBodySize, *flagMaxImportBodySize, cfg.MaxImportBodySize)
+	cfg.AllowedURLSchemes = setListValue(envAllowedURLSchemes, *flagAllowedURLSchemes, cfg.AllowedURLSchemes)
+	cfg.MaxURLLength = cfg.setInt64Value("max URL length", envMaxURLLength, *flagMaxURLLength, cfg.MaxURLLength)
+	cfg.StripURLFragment = cfg.setBoolValue("strip fragment", envStripURLFragment, *flagStripURLFragment, cfg.StripURLFragment)
+	cfg.SortURLQuery = cfg.setBoolValue("sort query", envSortURLQuery, *flagSortURLQuery, cfg.SortURLQuery)
+	cfg.BlocklistPath = setValue(envBlocklistPath, *flagBlocklistPath, cfg.BlocklistPath)
+	cfg.BlocklistReloadInterval = cfg.setDurationValue("blocklist reload interval", envBlocklistReloadInterval, *flagBlocklistReloadInterval, cfg.BlocklistReloadInterval)
+	cfg.DefaultRedirectType = cfg.setInt64Value("default redirect type", envDefaultRedirectType, *flagDefaultRedirectType, cfg.DefaultRedirectType)
+	cfg.PermanentRedirectMaxAge = cfg.setDurationValue("permanent redirect max age", envPermanentRedirectMaxAge, *flagPermanentRedirectMaxAge, cfg.PermanentRedirectMaxAge)
+	cfg.ComingSoonURL = setValue(envComingSoonURL, *flagComingSoonURL, cfg.ComingSoonURL)
+	cfg.GeoIPDatabasePath = setValue(envGeoIPDatabasePath, *flagGeoIPDatabasePath, cfg.GeoIPDatabasePath)
+	cfg.TrustedProxies = cfg.setPrefixListValue("trusted proxies", envTrustedProxies, *flagTrustedProxies, cfg.TrustedProxies)
+	cfg.DefaultPageSize = cfg.setInt64Value("default page size", envDefaultPageSize, *flagDefaultPageSize, cfg.DefaultPageSize)
+	cfg.MaxPageSize = cfg.setInt64Value("max page size", envMaxPageSize, *flagMaxPageSize, cfg.MaxPageSize)
+	cfg.CursorSecret = setValue(envCursorSecret, *flagCursorSecret, cfg.CursorSecret)
+	cfg.DomainCacheTTL = cfg.setDurationValue("domain cache TTL", envDomainCacheTTL, *flagDomainCacheTTL, cfg.DomainCacheTTL)
 
 	return cfg
 }
 
 func (c *Config) Validate() error {
 	errs := make([]error, 0, 3)
+	errs = append(errs, c.parseErrs...)
 
 	if c.ServerAddr == "" {
 		errs = append(errs, fmt.Errorf("server address cannot be empty"))
@@ -68,5 +253,35 @@ func (c *Config) Validate() error {
 		errs = append(errs,fmt.Errorf("base URL must start with http:// or https://"))
 	}
 
+	if c.MaxBodySize <= 0 || c.MaxDecompressedBodySize <= 0 || c.MaxImportBodySize <= 0 {
+		errs = append(errs, fmt.Errorf("max body sizes must be positive"))
+	}
+
+	if len(c.AllowedURLSchemes) == 0 {
+		errs = append(errs, fmt.Errorf("at least one URL scheme must be allowed"))
+	}
+
+	if c.MaxURLLength < 0 {
+		errs = append(errs, fmt.Errorf("max URL length cannot be negative"))
+	}
+
+	switch c.DefaultRedirectType {
+	case 301, 302, 307, 308:
+	default:
+		errs = append(errs, fmt.Errorf("default redirect type must be one of 301, 302, 307, 308"))
+	}
+
+	if c.ComingSoonURL != "" && !strings.HasPrefix(c.ComingSoonURL, "http://") && !strings.HasPrefix(c.ComingSoonURL, "https://") {
+		errs = append(errs, fmt.Errorf("coming soon URL must start with http:// or https://"))
+	}
+
+	if c.DefaultPageSize <= 0 || c.MaxPageSize < c.DefaultPageSize {
+		errs = append(errs, fmt.Errorf("default page size must be positive and not above the max page size"))
+	}
+
+	if c.DomainCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("domain cache TTL cannot be negative"))
+	}
+
 	return errors.Join(errs...)
 }
\ No newline at end of file
@@ -0,0 +1,55 @@
+// Package geoip resolves client addresses to countries using a local
+// MaxMind-format (.mmdb) database, such as GeoLite2-Country or GeoIP2-City.
+package geoip
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Locator looks up countries in an opened database. A nil Locator knows no countries
+type Locator struct {
+	reader *maxminddb.Reader
+}
+
+// record is the part of a country or city database record we need
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// Open opens the database at path. Empty path means no database
+func Open(path string) (*Locator, error) {
+	if path == "" {
+		return nil, nil
+	}
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Locator{reader: reader}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of addr, or an
+// empty string if it is unknown
+func (l *Locator) Country(addr netip.Addr) string {
+	if l == nil || !addr.IsValid() {
+		return ""
+	}
+
+	var result record
+	if err := l.reader.Lookup(net.IP(addr.Unmap().AsSlice()), &result); err != nil {
+		return ""
+	}
+	return result.Country.ISOCode
+}
+
+func (l *Locator) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.reader.Close()
+}
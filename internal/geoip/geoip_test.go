@@ -0,0 +1,21 @@
+package geoip
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	locator, err := Open("")
+	if err != nil || locator != nil {
+		t.Fatalf("empty path must mean no database, got %v, %v", locator, err)
+	}
+	if country := locator.Country(netip.MustParseAddr("198.51.100.1")); country != "" {
+		t.Errorf("nil locator must not know countries, got %q", country)
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Errorf("expected error for missing database")
+	}
+}
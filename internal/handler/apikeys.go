@@ -0,0 +1,409 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+	"github.com/advn1/url-shortener/internal/middleware"
+	"github.com/google/uuid"
+)
+
+// API key scopes
+const (
+	ScopeCreate    = "create"
+	ScopeReadStats = "read-stats"
+	ScopeDelete    = "delete"
+)
+
+var validScopes = []string{ScopeCreate, ScopeReadStats, ScopeDelete}
+
+var (
+	errNoAPIKey      = errors.New("no API key provided")
+	errInvalidAPIKey = errors.New("invalid or revoked API key")
+	errKeyNotFound   = errors.New("API key not found")
+)
+
+// APIKey is a credential for programmatic clients. Only the SHA-256 hash of
+// the key is stored; keys are random enough that a slow hash isn't needed.
+type APIKey struct {
+	ID        uuid.UUID  `json:"id"`
+	Owner     string     `json:"owner"`
+	Hash      string     `json:"key_hash"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// APIKeyResponse is what the admin API shows about a key. Key is only set once, on creation
+type APIKeyResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	Owner     string     `json:"owner"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Key       string     `json:"key,omitempty"`
+}
+
+type PostAPIKeyBody struct {
+	Owner  string   `json:"owner"`
+	Scopes []string `json:"scopes"`
+}
+
+func newAPIKeyResponse(k *APIKey) APIKeyResponse {
+	return APIKeyResponse{ID: k.ID, Owner: k.Owner, Scopes: k.Scopes, CreatedAt: k.CreatedAt, RevokedAt: k.RevokedAt}
+}
+
+// generate a new plaintext API key
+func generateAPIKey() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return "sk_" + hex.EncodeToString(key)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// isAdmin reports whether the request carries the admin token
+func (h *Handler) isAdmin(r *http.Request) bool {
+	token := bearerToken(r)
+	if h.AdminToken == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.AdminToken)) == 1
+}
+
+// authenticate resolves the API key of the request. It returns errNoAPIKey
+// when the request doesn't carry one at all
+func (h *Handler) authenticate(ctx context.Context, r *http.Request) (*APIKey, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, errNoAPIKey
+	}
+
+	key, err := h.findAPIKey(ctx, hashAPIKey(token))
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, errInvalidAPIKey
+	}
+	return key, nil
+}
+
+// requireScope authenticates the request and checks that its key has the scope.
+// On failure the error response is already written
+func (h *Handler) requireScope(w http.ResponseWriter, r *http.Request, scope string) (*APIKey, bool) {
+	key, err := h.authenticate(r.Context(), r)
+	if err != nil {
+		h.writeAuthError(w, err)
+		return nil, false
+	}
+	if !key.HasScope(scope) {
+		jsonutils.WriteJSONError(w, http.StatusForbidden, "Forbidden", "API key lacks the \""+scope+"\" scope")
+		return nil, false
+	}
+	return key, true
+}
+
+// adminPrincipal is who requests made with the admin token act as
+const adminPrincipal = "admin"
+
+// authorizeOwner lets the admin or the owner of the link (with a key that has
+// the scope) through and returns who the request acts as, adminPrincipal or
+// the owner. On failure the error response is already written
+func (h *Handler) authorizeOwner(w http.ResponseWriter, r *http.Request, link *Link, scope string) (principal string, ok bool) {
+	if h.isAdmin(r) {
+		return adminPrincipal, true
+	}
+
+	key, ok := h.requireScope(w, r, scope)
+	if !ok {
+		return "", false
+	}
+	if link.Owner == "" || key.Owner != link.Owner {
+		jsonutils.WriteJSONError(w, http.StatusForbidden, "Forbidden", "only the owner of the short URL can do this")
+		return "", false
+	}
+	return key.Owner, true
+}
+
+func (h *Handler) writeAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNoAPIKey):
+		jsonutils.WriteJSONError(w, http.StatusUnauthorized, "Unauthorized", "API key required")
+	case errors.Is(err, errInvalidAPIKey):
+		jsonutils.WriteJSONError(w, http.StatusUnauthorized, "Unauthorized", "invalid or revoked API key")
+	default:
+		h.logger.Errorw("API key lookup", "error", err)
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+	}
+}
+
+func (h *Handler) findAPIKey(ctx context.Context, hash string) (*APIKey, error) {
+	if h.dbConnection != nil {
+		key := APIKey{Hash: hash}
+		var scopes string
+		err := h.dbConnection.QueryRowContext(ctx, "SELECT id, owner, scopes, created_at, revoked_at FROM api_keys WHERE key_hash = $1", hash).
+			Scan(&key.ID, &key.Owner, &scopes, &key.CreatedAt, &key.RevokedAt)
+		if err == sql.ErrNoRows {
+			return nil, errInvalidAPIKey
+		}
+		if err != nil {
+			return nil, err
+		}
+		key.Scopes = splitScopes(scopes)
+		return &key, nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	key, exists := h.apiKeys[hash]
+	if !exists {
+		return nil, errInvalidAPIKey
+	}
+	return key, nil
+}
+
+func (h *Handler) saveAPIKey(ctx context.Context, key *APIKey) error {
+	if h.dbConnection != nil {
+		_, err := h.dbConnection.ExecContext(ctx, "INSERT INTO api_keys (id, owner, key_hash, scopes, created_at) VALUES ($1, $2, $3, $4, $5)",
+			key.ID, key.Owner, key.Hash, strings.Join(key.Scopes, ","), key.CreatedAt)
+		return err
+	}
+
+	if h.StoragePath != "" {
+		if err := h.appendRecord(fileRecord{Kind: recordAPIKey, APIKey: key}); err != nil {
+			return err
+		}
+	}
+
+	h.mu.Lock()
+	h.apiKeys[key.Hash] = key
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *Handler) listAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	if h.dbConnection != nil {
+		rows, err := h.reader().QueryContext(ctx, "SELECT id, owner, key_hash, scopes, created_at, revoked_at FROM api_keys ORDER BY created_at")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		keys := make([]*APIKey, 0)
+		for rows.Next() {
+			var key APIKey
+			var scopes string
+			if err := rows.Scan(&key.ID, &key.Owner, &key.Hash, &scopes, &key.CreatedAt, &key.RevokedAt); err != nil {
+				return nil, err
+			}
+			key.Scopes = splitScopes(scopes)
+			keys = append(keys, &key)
+		}
+		return keys, rows.Err()
+	}
+
+	h.mu.RLock()
+	keys := make([]*APIKey, 0, len(h.apiKeys))
+	for _, key := range h.apiKeys {
+		keys = append(keys, key)
+	}
+	h.mu.RUnlock()
+
+	slices.SortFunc(keys, func(a, b *APIKey) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return keys, nil
+}
+
+// revokeAPIKey marks the key as revoked. Revoked keys are kept for attribution of existing links
+func (h *Handler) revokeAPIKey(ctx context.Context, id uuid.UUID) (*APIKey, error) {
+	now := time.Now().UTC()
+
+	if h.dbConnection != nil {
+		key := APIKey{ID: id}
+		var scopes string
+		err := h.dbConnection.QueryRowContext(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1 RETURNING owner, key_hash, scopes, created_at, revoked_at", id, now).
+			Scan(&key.Owner, &key.Hash, &scopes, &key.CreatedAt, &key.RevokedAt)
+		if err == sql.ErrNoRows {
+			return nil, errKeyNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		key.Scopes = splitScopes(scopes)
+		return &key, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for hash, key := range h.apiKeys {
+		if key.ID != id {
+			continue
+		}
+		if key.RevokedAt != nil {
+			return key, nil
+		}
+
+		revoked := *key
+		revoked.RevokedAt = &now
+		if h.StoragePath != "" {
+			if err := h.appendRecord(fileRecord{Kind: recordAPIKey, APIKey: &revoked}); err != nil {
+				return nil, err
+			}
+		}
+		h.apiKeys[hash] = &revoked
+		return &revoked, nil
+	}
+	return nil, errKeyNotFound
+}
+
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return []string{}
+	}
+	return strings.Split(scopes, ",")
+}
+
+// handler for listing (GET) and creating (POST) API keys. Admin only
+func (h *Handler) HandleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleAPIKeys called", "path", r.URL.Path, "method", r.Method)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !h.isAdmin(r) {
+		jsonutils.WriteJSONError(w, http.StatusUnauthorized, "Unauthorized", "admin token required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := h.listAPIKeys(r.Context())
+		if err != nil {
+			h.logger.Errorw("List API keys", "error", err)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+			return
+		}
+
+		result := make([]APIKeyResponse, 0, len(keys))
+		for _, key := range keys {
+			result = append(result, newAPIKeyResponse(key))
+		}
+		json.NewEncoder(w).Encode(result)
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			if middleware.WriteBodyTooLarge(w, err) {
+				return
+			}
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Failed to read request body", "failed to read request body")
+			return
+		}
+
+		var postAPIKeyBody PostAPIKeyBody
+		if err := json.Unmarshal(body, &postAPIKeyBody); err != nil {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid JSON format", "")
+			return
+		}
+
+		postAPIKeyBody.Owner = strings.TrimSpace(postAPIKeyBody.Owner)
+		if postAPIKeyBody.Owner == "" {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Empty owner", "owner of the key must be set")
+			return
+		}
+		if len(postAPIKeyBody.Scopes) == 0 {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Empty scopes", "at least one scope must be set")
+			return
+		}
+		for _, scope := range postAPIKeyBody.Scopes {
+			if !slices.Contains(validScopes, scope) {
+				jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid scope", "unknown scope \""+scope+"\"")
+				return
+			}
+		}
+
+		plainKey := generateAPIKey()
+		key := &APIKey{
+			ID:        uuid.New(),
+			Owner:     postAPIKeyBody.Owner,
+			Hash:      hashAPIKey(plainKey),
+			Scopes:    slices.Compact(slices.Sorted(slices.Values(postAPIKeyBody.Scopes))),
+			CreatedAt: time.Now().UTC(),
+		}
+
+		if err := h.saveAPIKey(r.Context(), key); err != nil {
+			h.logger.Errorw("Save API key", "error", err)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "cannot save API key")
+			return
+		}
+
+		result := newAPIKeyResponse(key)
+		result.Key = plainKey
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(result)
+	default:
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+	}
+}
+
+// handler for revoking (DELETE) an API key. Admin only
+func (h *Handler) HandleAPIKeyById(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleAPIKeyById called", "path", r.URL.Path, "method", r.Method)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !h.isAdmin(r) {
+		jsonutils.WriteJSONError(w, http.StatusUnauthorized, "Unauthorized", "admin token required")
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid ID", "API key ID must be a UUID")
+		return
+	}
+
+	key, err := h.revokeAPIKey(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, errKeyNotFound) {
+			jsonutils.WriteJSONError(w, http.StatusNotFound, "Non existing ID", "provided API key ID doesn't exists")
+			return
+		}
+		h.logger.Errorw("Revoke API key", "error", err, "id", id)
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+		return
+	}
+
+	json.NewEncoder(w).Encode(newAPIKeyResponse(key))
+}
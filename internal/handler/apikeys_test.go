@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func newAdminHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+	h.AdminToken = "admin-secret"
+	return h
+}
+
+func createAPIKey(t *testing.T, h *Handler, body string) APIKeyResponse {
+	t.Helper()
+
+	r := httptest.NewRequest("POST", "/api/admin/keys", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+h.AdminToken)
+	w := httptest.NewRecorder()
+	h.HandleAPIKeys(w, r)
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("incorrect status code on key creation. Got %v, wanted %v", res.StatusCode, http.StatusCreated)
+	}
+
+	var key APIKeyResponse
+	if err := json.NewDecoder(res.Body).Decode(&key); err != nil {
+		t.Fatalf("error on decoding key response: %v", err)
+	}
+	return key
+}
+
+func shortenWithKey(h *Handler, key string) *http.Response {
+	r := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"https://youtube.com"}`))
+	r.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	h.HandlePostRESTApi(w, r)
+	return w.Result()
+}
+
+func TestAPIKeys_RequireAdmin(t *testing.T) {
+	h := newAdminHandler(t)
+
+	r := httptest.NewRequest("GET", "/api/admin/keys", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	h.HandleAPIKeys(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %v status code, got %v", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAPIKeys_CreateAndAttribute(t *testing.T) {
+	h := newAdminHandler(t)
+	key := createAPIKey(t, h, `{"owner":"ci","scopes":["create"]}`)
+
+	if !strings.HasPrefix(key.Key, "sk_") {
+		t.Fatalf("expected plaintext key in creation response, got %q", key.Key)
+	}
+	if _, exists := h.apiKeys[key.Key]; exists {
+		t.Errorf("plaintext key must not be stored")
+	}
+
+	res := shortenWithKey(h, key.Key)
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("incorrect status code. Got %v, wanted %v", res.StatusCode, http.StatusCreated)
+	}
+
+	var result PostURLResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatalf("error on decoding response: %v", err)
+	}
+
+	link := h.links[result.ShortUrl]
+	if link == nil || link.APIKeyID != key.ID.String() || link.Owner != "ci" {
+		t.Errorf("link is not attributed to the API key: %+v", link)
+	}
+}
+
+func TestAPIKeys_WrongScope(t *testing.T) {
+	h := newAdminHandler(t)
+	key := createAPIKey(t, h, `{"owner":"stats","scopes":["read-stats"]}`)
+
+	res := shortenWithKey(h, key.Key)
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected %v status code, got %v", http.StatusForbidden, res.StatusCode)
+	}
+}
+
+func TestAPIKeys_Revoke(t *testing.T) {
+	h := newAdminHandler(t)
+	key := createAPIKey(t, h, `{"owner":"ci","scopes":["create"]}`)
+
+	r := httptest.NewRequest("DELETE", "/api/admin/keys/"+key.ID.String(), nil)
+	r.SetPathValue("id", key.ID.String())
+	r.Header.Set("Authorization", "Bearer "+h.AdminToken)
+	w := httptest.NewRecorder()
+	h.HandleAPIKeyById(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code on revoke. Got %v, wanted %v", w.Code, http.StatusOK)
+	}
+
+	res := shortenWithKey(h, key.Key)
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected %v status code for revoked key, got %v", http.StatusUnauthorized, res.StatusCode)
+	}
+}
+
+func TestAPIKeys_PersistedInFile(t *testing.T) {
+	storagePath := t.TempDir() + "/storage.json"
+
+	h := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	h.AdminToken = "admin-secret"
+	key := createAPIKey(t, h, `{"owner":"ci","scopes":["create"]}`)
+
+	res := shortenWithKey(h, key.Key)
+	res.Body.Close()
+
+	reloaded := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading storage file: %v", err)
+	}
+
+	if _, err := reloaded.authenticate(t.Context(), httptest.NewRequest("GET", "/", nil)); err == nil {
+		t.Errorf("expected error for request without key")
+	}
+
+	res = shortenWithKey(reloaded, key.Key)
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("key was not restored from file. Got %v, wanted %v", res.StatusCode, http.StatusCreated)
+	}
+	if len(reloaded.URLs) != 2 {
+		t.Errorf("expected 2 links after reload, got %v", len(reloaded.URLs))
+	}
+}
@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+)
+
+// Click is a single followed redirect
+type Click struct {
+	ShortUrl  string    `json:"short_url"`
+	Domain    string    `json:"domain,omitempty"`
+	ClickedAt time.Time `json:"clicked_at"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// the URL the visitor was sent to, which differs from the link for rotated and platform destinations
+	Destination string `json:"destination,omitempty"`
+	Country     string `json:"country,omitempty"` // ISO code, empty without a GeoIP database
+}
+
+func newClick(r *http.Request, link *Link, destination string, country string) Click {
+	return Click{
+		ShortUrl:    link.ShortUrl,
+		Domain:      link.Domain,
+		ClickedAt:   time.Now().UTC(),
+		Referer:     r.Referer(),
+		UserAgent:   r.UserAgent(),
+		Destination: destination,
+		Country:     country,
+	}
+}
+
+var errClicksExhausted = errors.New("short URL has no clicks left")
+
+// saveClick stores the click and bumps the click counter of its link. Links
+// with MaxClicks fail with errClicksExhausted once the counter reaches it
+func (h *Handler) saveClick(ctx context.Context, link *Link, click Click) error {
+	if h.dbConnection != nil {
+		tx, err := h.dbConnection.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// the row lock taken by UPDATE makes concurrent clicks of a limited link wait for each other
+		var clicks int64
+		err = tx.QueryRowContext(ctx, "UPDATE urls SET clicks = clicks + 1 WHERE domain = $1 AND short_url = $2 AND (max_clicks IS NULL OR clicks < max_clicks) RETURNING clicks",
+			click.Domain, click.ShortUrl).Scan(&clicks)
+		if err == sql.ErrNoRows {
+			return errClicksExhausted
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO clicks (short_url, domain, clicked_at, referer, user_agent, destination, country)
+			VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''))`,
+			click.ShortUrl, click.Domain, click.ClickedAt, click.Referer, click.UserAgent, click.Destination, click.Country)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := linkKey(click.Domain, click.ShortUrl)
+	if link.MaxClicks > 0 && h.clickCounts[key] >= link.MaxClicks {
+		return errClicksExhausted
+	}
+
+	if h.StoragePath != "" {
+		if err := h.appendRecord(fileRecord{Kind: recordClick, Click: &click}); err != nil {
+			return err
+		}
+	}
+
+	h.countClick(&click)
+	return nil
+}
+
+// countClick bumps the click counters of the link of click. Callers hold h.mu
+func (h *Handler) countClick(click *Click) {
+	key := linkKey(click.Domain, click.ShortUrl)
+	h.clickCounts[key]++
+	if h.destinationClicks[key] == nil {
+		h.destinationClicks[key] = make(map[string]int64)
+	}
+	h.destinationClicks[key][click.Destination]++
+}
+
+// eachFileClick calls fn for every click in the storage file, oldest first.
+// Only the part of the file written before the call is read, so a click
+// being appended meanwhile is never read half written
+func (h *Handler) eachFileClick(fn func(*Click) error) error {
+	if h.StoragePath == "" {
+		return nil
+	}
+
+	h.mu.RLock()
+	info, err := os.Stat(h.StoragePath)
+	h.mu.RUnlock()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(h.StoragePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(io.LimitReader(file, info.Size()))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// most lines are clicks, the others are skipped without decoding them
+		line := scanner.Bytes()
+		if !bytes.Contains(line, []byte(`"kind":"click"`)) {
+			continue
+		}
+		var record fileRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		if record.Kind != recordClick || record.Click == nil {
+			continue
+		}
+		if err := fn(record.Click); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// countClicksByDestination returns how many clicks of a link went to each destination
+func (h *Handler) countClicksByDestination(ctx context.Context, link *Link) (map[string]int64, error) {
+	counts := make(map[string]int64)
+
+	if h.dbConnection != nil {
+		rows, err := h.dbConnection.QueryContext(ctx, "SELECT COALESCE(destination, ''), count(*) FROM clicks WHERE domain = $1 AND short_url = $2 GROUP BY 1",
+			link.Domain, link.ShortUrl)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var destination string
+			var count int64
+			if err := rows.Scan(&destination, &count); err != nil {
+				return nil, err
+			}
+			counts[destination] = count
+		}
+		return counts, rows.Err()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for destination, count := range h.destinationClicks[link.key()] {
+		counts[destination] = count
+	}
+	return counts, nil
+}
+
+// recordClick saves a click of the request. A click-limited link must not
+// redirect without claiming its click, so its errors are returned. For other
+// links a failure is logged but never stops the redirect
+func (h *Handler) recordClick(r *http.Request, link *Link, destination string, country string) error {
+	err := h.saveClick(r.Context(), link, newClick(r, link, destination, country))
+	if err == nil || link.MaxClicks > 0 {
+		return err
+	}
+	h.logger.Errorw("Save click", "error", err, "id", link.ShortUrl)
+	return nil
+}
+
+// writeClickError writes the response for a click that could not be claimed
+func (h *Handler) writeClickError(w http.ResponseWriter, err error, link *Link) {
+	w.Header().Set("Cache-Control", "no-store")
+	if errors.Is(err, errClicksExhausted) {
+		jsonutils.WriteJSONError(w, http.StatusGone, "Exhausted", "short URL has reached its click limit")
+		return
+	}
+	h.logger.Errorw("Save click", "error", err, "id", link.ShortUrl)
+	jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+}
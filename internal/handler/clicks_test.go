@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// storedClicks reads back the clicks of a file backed handler
+func storedClicks(t *testing.T, h *Handler) []Click {
+	t.Helper()
+
+	var clicks []Click
+	err := h.eachClick(context.Background(), ExportFilter{}, func(click *Click) error {
+		clicks = append(clicks, *click)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error on reading clicks: %v", err)
+	}
+	return clicks
+}
+
+func TestMaxClicks_OneTimeLink(t *testing.T) {
+	storagePath := t.TempDir() + "/storage.json"
+	h := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	id := createLink(t, h, `{"url":"https://example.com/invite","max_clicks":1,"redirect_type":308}`)
+
+	w := visit(h, id)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("click-limited link must redirect temporarily, got %v", w.Code)
+	}
+	if w := visit(h, id); w.Code != http.StatusGone {
+		t.Errorf("expected %v status code, got %v", http.StatusGone, w.Code)
+	}
+
+	reloaded := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading storage file: %v", err)
+	}
+	if w := visit(reloaded, id); w.Code != http.StatusGone {
+		t.Errorf("expected %v status code after reload, got %v", http.StatusGone, w.Code)
+	}
+}
+
+func TestMaxClicks_Concurrent(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+	id := createLink(t, h, `{"url":"https://example.com/invite","max_clicks":3}`)
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		redirected int
+	)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if visit(h, id).Code == http.StatusTemporaryRedirect {
+				mu.Lock()
+				redirected++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if redirected != 3 {
+		t.Errorf("expected 3 redirects, got %v", redirected)
+	}
+}
+
+func TestClicks_ReadBackFromFile(t *testing.T) {
+	storagePath := t.TempDir() + "/storage.json"
+	h := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	id := createLink(t, h, `{"url":"https://example.com/a"}`)
+	visit(h, id)
+	visit(h, id)
+
+	reloaded := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading storage file: %v", err)
+	}
+	if reloaded.clickCounts[id] != 2 || reloaded.destinationClicks[id]["https://example.com/a"] != 2 {
+		t.Errorf("click counters not restored: %v %v", reloaded.clickCounts, reloaded.destinationClicks)
+	}
+	if clicks := storedClicks(t, reloaded); len(clicks) != 2 || clicks[0].ShortUrl != id {
+		t.Errorf("clicks not read back from the file: %+v", clicks)
+	}
+}
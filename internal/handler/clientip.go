@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientAddr returns the address of the visitor. Forwarding headers are only
+// honored when the request comes from one of the TrustedProxies, and
+// X-Forwarded-For is read from the right so a client cannot spoof its address
+func (h *Handler) clientAddr(r *http.Request) netip.Addr {
+	remote := parseAddr(r.RemoteAddr)
+	if !h.isTrustedProxy(remote) {
+		return remote
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := parseAddr(strings.TrimSpace(hops[i]))
+			if !hop.IsValid() {
+				break
+			}
+			client = hop
+			if !h.isTrustedProxy(hop) {
+				break
+			}
+		}
+		return client
+	}
+
+	if realIP := parseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP.IsValid() {
+		return realIP
+	}
+	return remote
+}
+
+// clientIP is clientAddr as a string, falling back to RemoteAddr when it can't be parsed
+func (h *Handler) clientIP(r *http.Request) string {
+	if addr := h.clientAddr(r); addr.IsValid() {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+func (h *Handler) isTrustedProxy(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, prefix := range h.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddr parses "ip" or "ip:port". It returns the zero Addr on failure
+func parseAddr(s string) netip.Addr {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -0,0 +1,328 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+	"github.com/advn1/url-shortener/internal/middleware"
+	"github.com/advn1/url-shortener/internal/validator"
+)
+
+// Domain is an additional domain short links can be served from. Links on
+// the domain of BaseURL have an empty Domain
+type Domain struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PostDomainBody struct {
+	Name string `json:"name"`
+}
+
+var (
+	errUnknownDomain = errors.New("domain is not registered")
+	errDomainExists  = errors.New("domain is already registered")
+)
+
+// linkKey identifies a link in the in-memory maps. Codes on the default
+// domain are used as they are, so older storage files keep working
+func linkKey(domain string, code string) string {
+	if domain == "" {
+		return code
+	}
+	return domain + "/" + code
+}
+
+func (l *Link) key() string {
+	return linkKey(l.Domain, l.ShortUrl)
+}
+
+// defaultDomain returns the host name of BaseURL
+func (h *Handler) defaultDomain() string {
+	u, err := url.Parse(h.BaseURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// linkURL returns the full short link
+func (h *Handler) linkURL(link *Link) string {
+	if link.Domain == "" {
+		return h.BaseURL + "/" + link.ShortUrl
+	}
+	scheme := "https"
+	if u, err := url.Parse(h.BaseURL); err == nil && u.Scheme != "" {
+		scheme = u.Scheme
+	}
+	return scheme + "://" + link.Domain + "/" + link.ShortUrl
+}
+
+func (h *Handler) domainExists(ctx context.Context, name string) (bool, error) {
+	if h.dbConnection != nil {
+		var exists bool
+		err := h.dbConnection.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM domains WHERE name = $1)", name).Scan(&exists)
+		return exists, err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, exists := h.domains[name]
+	return exists, nil
+}
+
+// domainCache holds the names of the domains registered in the database, so
+// that redirects on unregistered hosts, like the address of the server
+// itself, don't cost a query each. Domains are never removed, so the cache
+// only has to be refreshed for ones registered by other instances
+type domainCache struct {
+	mu       sync.RWMutex
+	names    map[string]bool
+	loadedAt time.Time
+}
+
+// lookup reports whether name is registered, loading the names with load
+// when the cache is older than ttl
+func (c *domainCache) lookup(name string, ttl time.Duration, load func() ([]*Domain, error)) (bool, error) {
+	c.mu.RLock()
+	if c.names != nil && time.Since(c.loadedAt) < ttl {
+		defer c.mu.RUnlock()
+		return c.names[name], nil
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// another request may have refreshed it meanwhile
+	if c.names != nil && time.Since(c.loadedAt) < ttl {
+		return c.names[name], nil
+	}
+	domains, err := load()
+	if err != nil {
+		return false, err
+	}
+	c.names = make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		c.names[domain.Name] = true
+	}
+	c.loadedAt = time.Now()
+	return c.names[name], nil
+}
+
+// add caches a domain registered by this instance
+func (c *domainCache) add(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.names != nil {
+		c.names[name] = true
+	}
+}
+
+// resolveDomain turns a domain chosen by a client into the Domain of a link.
+// The default domain and an empty name both mean ""
+func (h *Handler) resolveDomain(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	name, err := validator.NormalizeDomain(name)
+	if err != nil {
+		return "", err
+	}
+	if name == h.defaultDomain() {
+		return "", nil
+	}
+
+	exists, err := h.domainExists(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", errUnknownDomain
+	}
+	return name, nil
+}
+
+// requestDomain returns the domain a short link was visited on. Hosts that
+// are not registered, like the address of the server itself, are treated as
+// the default domain. A failed lookup is an error rather than the default
+// domain, which could serve a link of the same code there
+func (h *Handler) requestDomain(r *http.Request) (string, error) {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || host == h.defaultDomain() {
+		return "", nil
+	}
+
+	var exists bool
+	var err error
+	if h.dbConnection != nil {
+		exists, err = h.domainCache.lookup(host, h.DomainCacheTTL, func() ([]*Domain, error) { return h.listDomains(r.Context()) })
+	} else {
+		exists, err = h.domainExists(r.Context(), host)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", nil
+	}
+	return host, nil
+}
+
+// queryDomain resolves the optional domain query parameter of the REST API.
+// It writes the error response and returns false when the domain is invalid
+func (h *Handler) queryDomain(w http.ResponseWriter, r *http.Request) (string, bool) {
+	domain, err := h.resolveDomain(r.Context(), r.URL.Query().Get("domain"))
+	if err != nil {
+		h.writeDomainError(w, err)
+		return "", false
+	}
+	return domain, true
+}
+
+func (h *Handler) writeDomainError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnknownDomain):
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Unknown domain", err.Error())
+	case errors.Is(err, validator.ErrInvalid), errors.Is(err, validator.ErrNoHost):
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid domain", err.Error())
+	default:
+		h.logger.Errorw("Domain lookup", "error", err)
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+	}
+}
+
+func (h *Handler) listDomains(ctx context.Context) ([]*Domain, error) {
+	if h.dbConnection != nil {
+		rows, err := h.reader().QueryContext(ctx, "SELECT name, created_at FROM domains ORDER BY name")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		domains := make([]*Domain, 0)
+		for rows.Next() {
+			var domain Domain
+			if err := rows.Scan(&domain.Name, &domain.CreatedAt); err != nil {
+				return nil, err
+			}
+			domains = append(domains, &domain)
+		}
+		return domains, rows.Err()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	domains := make([]*Domain, 0, len(h.domains))
+	for _, domain := range h.domains {
+		domains = append(domains, domain)
+	}
+	slices.SortFunc(domains, func(a, b *Domain) int { return strings.Compare(a.Name, b.Name) })
+	return domains, nil
+}
+
+func (h *Handler) saveDomain(ctx context.Context, domain *Domain) error {
+	if h.dbConnection != nil {
+		result, err := h.dbConnection.ExecContext(ctx, "INSERT INTO domains (name, created_at) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING",
+			domain.Name, domain.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if inserted, err := result.RowsAffected(); err == nil && inserted == 0 {
+			return errDomainExists
+		}
+		h.domainCache.add(domain.Name)
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.domains[domain.Name]; exists {
+		return errDomainExists
+	}
+	if h.StoragePath != "" {
+		if err := h.appendRecord(fileRecord{Kind: recordDomain, Domain: domain}); err != nil {
+			return err
+		}
+	}
+	h.domains[domain.Name] = domain
+	return nil
+}
+
+// handler for listing (GET) and registering (POST) domains. Admin only
+func (h *Handler) HandleDomains(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleDomains called", "path", r.URL.Path, "method", r.Method)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !h.isAdmin(r) {
+		jsonutils.WriteJSONError(w, http.StatusUnauthorized, "Unauthorized", "admin token required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		domains, err := h.listDomains(r.Context())
+		if err != nil {
+			h.logger.Errorw("List domains", "error", err)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+			return
+		}
+		json.NewEncoder(w).Encode(domains)
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			if middleware.WriteBodyTooLarge(w, err) {
+				return
+			}
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Failed to read request body", "failed to read request body")
+			return
+		}
+
+		var postDomainBody PostDomainBody
+		if err := json.Unmarshal(body, &postDomainBody); err != nil {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid JSON format", "")
+			return
+		}
+
+		name, err := validator.NormalizeDomain(postDomainBody.Name)
+		if err != nil {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid domain", err.Error())
+			return
+		}
+		if name == h.defaultDomain() {
+			jsonutils.WriteJSONError(w, http.StatusConflict, "Domain exists", "domain is the default domain")
+			return
+		}
+
+		domain := &Domain{Name: name, CreatedAt: time.Now().UTC()}
+		if err := h.saveDomain(r.Context(), domain); err != nil {
+			if errors.Is(err, errDomainExists) {
+				jsonutils.WriteJSONError(w, http.StatusConflict, "Domain exists", err.Error())
+				return
+			}
+			h.logger.Errorw("Save domain", "error", err)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "cannot save domain")
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(domain)
+	default:
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+	}
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+)
+
+func registerDomain(t *testing.T, h *Handler, name string) {
+	t.Helper()
+
+	r := httptest.NewRequest("POST", "/api/admin/domains", strings.NewReader(`{"name":"`+name+`"}`))
+	r.Header.Set("Authorization", "Bearer "+h.AdminToken)
+	w := httptest.NewRecorder()
+	h.HandleDomains(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("incorrect status code on domain registration. Got %v, wanted %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
+
+func visitHost(h *Handler, host string, id string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/"+id, nil)
+	r.Host = host
+	w := httptest.NewRecorder()
+	h.HandleGetById(w, r)
+	return w
+}
+
+func TestDomains_SameCodeOnTwoDomains(t *testing.T) {
+	storagePath := t.TempDir() + "/storage.json"
+	h := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	h.AdminToken = "admin-secret"
+	registerDomain(t, h, "Go.Brand-A.com")
+	registerDomain(t, h, "brand-b.link")
+
+	for _, link := range []*Link{
+		{ShortUrl: "spring", OriginalUrl: "https://brand-a.com/spring", Domain: "go.brand-a.com"},
+		{ShortUrl: "spring", OriginalUrl: "https://brand-b.com/spring", Domain: "brand-b.link"},
+		{ShortUrl: "spring", OriginalUrl: "https://example.com/spring"},
+	} {
+		if err := h.saveLink(t.Context(), link); err != nil {
+			t.Fatalf("error on saving link: %v", err)
+		}
+	}
+
+	reloaded := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading storage file: %v", err)
+	}
+
+	tests := []struct {
+		host     string
+		location string
+	}{
+		{"go.brand-a.com", "https://brand-a.com/spring"},
+		{"brand-b.link:443", "https://brand-b.com/spring"},
+		{"localhost:8080", "https://example.com/spring"},
+		{"unknown.example", "https://example.com/spring"},
+	}
+	for _, test := range tests {
+		if location := visitHost(reloaded, test.host, "spring").Header().Get("Location"); location != test.location {
+			t.Errorf("incorrect Location on %s. Got %q, wanted %q", test.host, location, test.location)
+		}
+	}
+}
+
+func TestDomains_CreateOnDomain(t *testing.T) {
+	h := newAdminHandler(t)
+	registerDomain(t, h, "brand-b.link")
+
+	w := postJSON(h, `{"url":"https://brand-b.com","domain":"brand-b.link","qr":true}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var result PostURLResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("error on unmarshalling response body: %v", err)
+	}
+	if result.Domain != "brand-b.link" {
+		t.Errorf("incorrect domain %q", result.Domain)
+	}
+
+	if w := visitHost(h, "brand-b.link", result.ShortUrl); w.Header().Get("Location") != "https://brand-b.com" {
+		t.Errorf("link not served on its domain: %v", w.Code)
+	}
+	if w := visitHost(h, "localhost:8080", result.ShortUrl); w.Code != http.StatusBadRequest {
+		t.Errorf("link must not be served on the default domain, got %v", w.Code)
+	}
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader("https://brand-b.com/plain"))
+	r.Host = "brand-b.link"
+	w = httptest.NewRecorder()
+	h.HandlePost(w, r)
+	if !strings.HasPrefix(w.Body.String(), "http://brand-b.link/") {
+		t.Errorf("plain text links must be created on the requested domain, got %q", w.Body.String())
+	}
+
+	if w := postJSON(h, `{"url":"https://brand-b.com","domain":"unregistered.link"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestDomains_RequireAdmin(t *testing.T) {
+	h := newAdminHandler(t)
+
+	r := httptest.NewRequest("POST", "/api/admin/domains", strings.NewReader(`{"name":"brand-b.link"}`))
+	w := httptest.NewRecorder()
+	h.HandleDomains(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %v status code, got %v", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestDomains_LookupErrorIsNotDefaultDomain(t *testing.T) {
+	// nothing listens on port 1, so every query fails
+	db, err := sql.Open("pgx", "postgres://localhost:1/shortener?connect_timeout=1")
+	if err != nil {
+		t.Fatalf("error on opening database: %v", err)
+	}
+	defer db.Close()
+	h := New("http://localhost:8080", make(map[string]string), "", db, zap.NewNop().Sugar())
+
+	r := httptest.NewRequest("GET", "/e1ef4c662c790d8e4f72", nil)
+	r.Host = "go.brand-a.com"
+	if domain, err := h.requestDomain(r); err == nil {
+		t.Errorf("expected a lookup error, got domain %q", domain)
+	}
+	if w := visitHost(h, "go.brand-a.com", "e1ef4c662c790d8e4f72"); w.Code != http.StatusInternalServerError {
+		t.Errorf("incorrect status code. Got %v, wanted %v", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestDomainCache_LoadsOncePerTTL(t *testing.T) {
+	var cache domainCache
+	loads := 0
+	load := func() ([]*Domain, error) {
+		loads++
+		return []*Domain{{Name: "go.example.com"}}, nil
+	}
+
+	for _, host := range []string{"go.example.com", "10.0.0.1", "10.0.0.1"} {
+		if _, err := cache.lookup(host, time.Minute, load); err != nil {
+			t.Fatalf("error on lookup: %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected domains to be loaded once, got %v loads", loads)
+	}
+
+	cache.add("links.example.org")
+	if registered, _ := cache.lookup("links.example.org", time.Minute, load); !registered || loads != 1 {
+		t.Errorf("registered domain not cached: %v after %v loads", registered, loads)
+	}
+	if registered, _ := cache.lookup("10.0.0.1", 0, load); registered || loads != 2 {
+		t.Errorf("expected a reload once the cache is stale, got %v loads", loads)
+	}
+}
@@ -0,0 +1,322 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+)
+
+// formats of exports
+const (
+	ExportNDJSON = "ndjson"
+	ExportCSV    = "csv"
+)
+
+// exports are flushed to the client every exportFlushEvery records
+const exportFlushEvery = 500
+
+// ExportFilter selects what an export holds. Links are filtered by creation
+// time and clicks by click time. Zero fields match everything
+type ExportFilter struct {
+	Owner  string
+	From   *time.Time
+	To     *time.Time
+	Clicks bool
+}
+
+func (f ExportFilter) inRange(t time.Time) bool {
+	return (f.From == nil || !t.Before(*f.From)) && (f.To == nil || t.Before(*f.To))
+}
+
+// eachLink calls fn for every link matching filter, oldest first. In the
+// database mode links are streamed from the query instead of loaded at once
+func (h *Handler) eachLink(ctx context.Context, filter ExportFilter, fn func(*Link) error) error {
+	if h.dbConnection != nil {
+		rows, err := h.reader().QueryContext(ctx, "SELECT "+linkColumns+` FROM urls
+			WHERE ($1 = '' OR owner = $1) AND ($2::timestamptz IS NULL OR created_at >= $2) AND ($3::timestamptz IS NULL OR created_at < $3)
+			ORDER BY created_at, id`, filter.Owner, filter.From, filter.To)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			link, err := scanLink(rows)
+			if err != nil {
+				return err
+			}
+			if err := fn(link); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+
+	// the lock isn't held while writing to the client
+	h.mu.RLock()
+	links := make([]*Link, 0, len(h.links))
+	for key, stored := range h.links {
+		if (filter.Owner == "" || stored.Owner == filter.Owner) && filter.inRange(stored.CreatedAt) {
+			link := *stored
+			link.OriginalUrl = h.URLs[key]
+			link.Clicks = h.clickCounts[key]
+			links = append(links, &link)
+		}
+	}
+	h.mu.RUnlock()
+
+	slices.SortFunc(links, func(a, b *Link) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Uuid.String(), b.Uuid.String())
+	})
+	for _, link := range links {
+		if err := fn(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachClick calls fn for every click matching filter, oldest first
+func (h *Handler) eachClick(ctx context.Context, filter ExportFilter, fn func(*Click) error) error {
+	if h.dbConnection != nil {
+		rows, err := h.reader().QueryContext(ctx, `SELECT c.short_url, c.domain, c.clicked_at, COALESCE(c.referer, ''), COALESCE(c.user_agent, ''),
+			COALESCE(c.destination, ''), COALESCE(c.country, '')
+			FROM clicks c JOIN urls u ON u.domain = c.domain AND u.short_url = c.short_url
+			WHERE ($1 = '' OR u.owner = $1) AND ($2::timestamptz IS NULL OR c.clicked_at >= $2) AND ($3::timestamptz IS NULL OR c.clicked_at < $3)
+			ORDER BY c.clicked_at, c.id`, filter.Owner, filter.From, filter.To)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var click Click
+			if err := rows.Scan(&click.ShortUrl, &click.Domain, &click.ClickedAt, &click.Referer, &click.UserAgent, &click.Destination, &click.Country); err != nil {
+				return err
+			}
+			if err := fn(&click); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+
+	// clicks are appended as they happen, so they are already oldest first
+	return h.eachFileClick(func(click *Click) error {
+		if !filter.inRange(click.ClickedAt) {
+			return nil
+		}
+		// the file still has clicks of deleted links, and of a deleted link
+		// whose short code was taken again before it was created
+		key := linkKey(click.Domain, click.ShortUrl)
+		h.mu.RLock()
+		_, stored := h.URLs[key]
+		link := h.links[key]
+		h.mu.RUnlock()
+		if !stored || (link != nil && click.ClickedAt.Before(link.CreatedAt)) {
+			return nil
+		}
+		if filter.Owner != "" && (link == nil || link.Owner != filter.Owner) {
+			return nil
+		}
+		return fn(click)
+	})
+}
+
+// exportLink is a link as exported. Password hashes stay on the server: the
+// empty PasswordHash shadows the one of Link, and Clicks is the one Link hides
+type exportLink struct {
+	Type string `json:"type"`
+	*Link
+	PasswordHash      string `json:"password_hash,omitempty"`
+	PasswordProtected bool   `json:"password_protected,omitempty"`
+	Clicks            int64  `json:"clicks"`
+}
+
+type exportClick struct {
+	Type string `json:"type"`
+	*Click
+}
+
+// exportWriter writes the records of an export in one of its formats
+type exportWriter interface {
+	link(*Link) error
+	click(*Click) error
+	flush() error
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (e *ndjsonExportWriter) link(link *Link) error {
+	return e.encoder.Encode(exportLink{Type: "link", Link: link, PasswordProtected: link.PasswordHash != "", Clicks: link.Clicks})
+}
+
+func (e *ndjsonExportWriter) click(click *Click) error {
+	return e.encoder.Encode(exportClick{Type: "click", Click: click})
+}
+
+func (e *ndjsonExportWriter) flush() error {
+	return nil
+}
+
+// csvExportWriter writes links and clicks as rows of a single table, each
+// kind filling in its own columns
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+var exportCSVHeader = []string{"type", "domain", "short_url", "original_url", "owner", "created_at", "expires_at", "tags", "folder", "flagged",
+	"password_protected", "clicks", "clicked_at", "referer", "user_agent", "destination", "country"}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func newCSVExportWriter(w io.Writer) (*csvExportWriter, error) {
+	writer := csv.NewWriter(w)
+	return &csvExportWriter{writer: writer}, writer.Write(exportCSVHeader)
+}
+
+func (e *csvExportWriter) link(link *Link) error {
+	return e.writer.Write([]string{"link", link.Domain, link.ShortUrl, link.OriginalUrl, link.Owner, formatExportTime(&link.CreatedAt), formatExportTime(link.NotAfter),
+		strings.Join(link.Tags, ";"), link.Folder, strconv.FormatBool(link.Flagged), strconv.FormatBool(link.PasswordHash != ""), strconv.FormatInt(link.Clicks, 10),
+		"", "", "", "", ""})
+}
+
+func (e *csvExportWriter) click(click *Click) error {
+	return e.writer.Write([]string{"click", click.Domain, click.ShortUrl, "", "", "", "", "", "", "", "", "",
+		formatExportTime(&click.ClickedAt), click.Referer, click.UserAgent, click.Destination, click.Country})
+}
+
+func (e *csvExportWriter) flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// parseExportFilter reads the filter of an export from the query string
+func parseExportFilter(r *http.Request) (ExportFilter, error) {
+	query := r.URL.Query()
+	filter := ExportFilter{Owner: strings.TrimSpace(query.Get("owner"))}
+
+	for name, bound := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, errors.New(name + " must be an RFC 3339 time")
+			}
+			*bound = &parsed
+		}
+	}
+	if value := query.Get("clicks"); value != "" {
+		clicks, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, errors.New("clicks must be a boolean")
+		}
+		filter.Clicks = clicks
+	}
+	return filter, nil
+}
+
+// handler for exports (GET) of links, and optionally of their clicks, as
+// NDJSON or CSV. The export is streamed, so a failure halfway through can only
+// cut the response short. Only the admin may export
+func (h *Handler) HandleExport(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleExport called", "path", r.URL.Path)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !h.isAdmin(r) {
+		jsonutils.WriteJSONError(w, http.StatusUnauthorized, "Unauthorized", "admin token required")
+		return
+	}
+	if r.Method != http.MethodGet {
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+		return
+	}
+
+	filter, err := parseExportFilter(r)
+	if err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	var writer exportWriter
+	switch format {
+	case "", ExportNDJSON:
+		format = ExportNDJSON
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		writer = &ndjsonExportWriter{encoder: json.NewEncoder(w)}
+	case ExportCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		if writer, err = newCSVExportWriter(w); err != nil {
+			h.logger.Errorw("Export", "error", err)
+			return
+		}
+	default:
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid request", "format must be ndjson or csv")
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="export-`+time.Now().UTC().Format("20060102-150405")+"."+format+`"`)
+
+	// written records are flushed every now and then so the client sees progress
+	// and compressed output doesn't pile up in buffers. Flushing also marks the
+	// response as a stream for the compression middleware
+	controller := http.NewResponseController(w)
+	flushAll := func() error {
+		if err := writer.flush(); err != nil {
+			return err
+		}
+		if err := controller.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	}
+	written := 0
+	flush := func() error {
+		written++
+		if written%exportFlushEvery != 0 {
+			return nil
+		}
+		return flushAll()
+	}
+
+	err = h.eachLink(r.Context(), filter, func(link *Link) error {
+		if err := writer.link(link); err != nil {
+			return err
+		}
+		return flush()
+	})
+	if err == nil && filter.Clicks {
+		err = h.eachClick(r.Context(), filter, func(click *Click) error {
+			if err := writer.click(click); err != nil {
+				return err
+			}
+			return flush()
+		})
+	}
+	if err == nil {
+		err = flushAll()
+	}
+	if err != nil {
+		h.logger.Errorw("Export cut short", "error", err, "records", written)
+		return
+	}
+	h.logger.Infow("Export done", "records", written)
+}
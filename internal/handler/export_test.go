@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/advn1/url-shortener/internal/middleware"
+	"go.uber.org/zap"
+)
+
+func getExport(h http.Handler, token string, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/api/export?"+query, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestExport_NDJSONWithClicks(t *testing.T) {
+	h := newAdminHandler(t)
+	h.StoragePath = t.TempDir() + "/storage.json"
+	marketing := createAPIKey(t, h, `{"owner":"marketing","scopes":["create"]}`)
+	growth := createAPIKey(t, h, `{"owner":"growth","scopes":["create"]}`)
+
+	id := createLabeledLink(t, h, marketing.Key, `{"url":"https://example.com/sale","password":"hunter22","tags":["sale"]}`)
+	createOwnedLink(t, h, growth.Key, "https://example.com/growth")
+	r := httptest.NewRequest("POST", "/"+id, strings.NewReader("password=hunter22"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.HandleGetById(httptest.NewRecorder(), r)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/export", h.HandleExport)
+	handler := middleware.GzipMiddleware(middleware.LoggingMiddleware(mux, zap.NewNop().Sugar()))
+
+	w := getExport(handler, h.AdminToken, "owner=marketing&clicks=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("export is not compressed")
+	}
+
+	body, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("error on decompressing export: %v", err)
+	}
+	var records []map[string]any
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("error on decoding export line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 2 || records[0]["type"] != "link" || records[1]["type"] != "click" {
+		t.Fatalf("unexpected export: %v", records)
+	}
+	if _, leaked := records[0]["password_hash"]; leaked || records[0]["password_protected"] != true || records[0]["clicks"] != 1.0 {
+		t.Errorf("unexpected exported link: %v", records[0])
+	}
+}
+
+func TestExport_CSVAndAccess(t *testing.T) {
+	h := newAdminHandler(t)
+	key := createAPIKey(t, h, `{"owner":"marketing","scopes":["create","read-stats"]}`)
+	createLabeledLink(t, h, key.Key, `{"url":"https://example.com/a","alias":"a","tags":["x","y"]}`)
+	createLabeledLink(t, h, key.Key, `{"url":"https://example.com/b","alias":"b"}`)
+
+	w := getExport(http.HandlerFunc(h.HandleExport), h.AdminToken, "format=csv")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("error on reading CSV export: %v", err)
+	}
+	if len(rows) != 3 || rows[1][2] != "a" || rows[1][7] != "x;y" || rows[2][2] != "b" {
+		t.Errorf("unexpected CSV export: %v", rows)
+	}
+
+	if w := getExport(http.HandlerFunc(h.HandleExport), h.AdminToken, "from=2000-01-01T00:00:00Z&to=2001-01-01T00:00:00Z"); w.Body.Len() != 0 {
+		t.Errorf("export out of the date range is not empty: %s", w.Body.String())
+	}
+	if w := getExport(http.HandlerFunc(h.HandleExport), key.Key, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %v status code, got %v", http.StatusUnauthorized, w.Code)
+	}
+	if w := getExport(http.HandlerFunc(h.HandleExport), h.AdminToken, "format=xml"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// CountryLocator resolves an address to an ISO 3166-1 alpha-2 country code,
+// or an empty string when unknown. *geoip.Locator implements it
+type CountryLocator interface {
+	Country(addr netip.Addr) string
+}
+
+// visitorCountry returns the country of the client, if a GeoIP database is loaded
+func (h *Handler) visitorCountry(r *http.Request) string {
+	if h.GeoIP == nil {
+		return ""
+	}
+	return h.GeoIP.Country(h.clientAddr(r))
+}
+
+// CountryDestination returns the override for the country, if any
+func (l *Link) CountryDestination(country string) (string, bool) {
+	if len(l.CountryURLs) == 0 || country == "" {
+		return "", false
+	}
+	destination, ok := l.CountryURLs[country]
+	return destination, ok
+}
+
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeCountryURLs upper-cases the country codes and normalizes the URLs
+func (h *Handler) normalizeCountryURLs(countryURLs URLOverrides) (URLOverrides, error) {
+	upper := make(URLOverrides, len(countryURLs))
+	for country, destination := range countryURLs {
+		country = strings.ToUpper(country)
+		if _, exists := upper[country]; exists {
+			return nil, fmt.Errorf("country %s is listed twice", country)
+		}
+		upper[country] = destination
+	}
+	return h.normalizeOverrides(upper)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// fakeLocator resolves addresses from a fixed table
+type fakeLocator map[string]string
+
+func (l fakeLocator) Country(addr netip.Addr) string {
+	return l[addr.String()]
+}
+
+func TestClientAddr_TrustedProxies(t *testing.T) {
+	h := newAdminHandler(t)
+	h.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:5000", "", "203.0.113.7"},
+		{"untrusted proxy is ignored", "203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:5000", "198.51.100.1", "198.51.100.1"},
+		{"spoofed hop is skipped", "10.0.0.2:5000", "192.0.2.66, 198.51.100.1, 10.0.0.3", "198.51.100.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			if test.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+			if got := h.clientIP(r); got != test.want {
+				t.Errorf("incorrect client IP. Got %q, wanted %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCountryURLs(t *testing.T) {
+	h := newAdminHandler(t)
+	h.StoragePath = t.TempDir() + "/storage.json"
+	h.GeoIP = fakeLocator{"198.51.100.1": "DE"}
+
+	id := createLink(t, h, `{"url":"https://example.com/shop","country_urls":{"de":"https://example.de/shop"},"redirect_type":308}`)
+
+	r := httptest.NewRequest("GET", "/"+id, nil)
+	r.RemoteAddr = "198.51.100.1:5000"
+	w := httptest.NewRecorder()
+	h.HandleGetById(w, r)
+
+	if w.Header().Get("Location") != "https://example.de/shop" {
+		t.Errorf("incorrect Location for DE visitor: %q", w.Header().Get("Location"))
+	}
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("geo-targeted link must redirect temporarily, got %v", w.Code)
+	}
+	if w := visit(h, id); w.Header().Get("Location") != "https://example.com/shop" {
+		t.Errorf("incorrect Location for other visitors: %q", w.Header().Get("Location"))
+	}
+
+	if clicks := storedClicks(t, h); len(clicks) != 2 || clicks[0].Country != "DE" || clicks[1].Country != "" {
+		t.Errorf("country not recorded on clicks: %+v", clicks)
+	}
+	if info := getURLInfo(t, h, id, ""); info.CountryURLs["DE"] != "https://example.de/shop" {
+		t.Errorf("metadata does not include country URLs: %+v", info)
+	}
+
+	if w := postJSON(h, `{"url":"https://example.com","country_urls":{"Germany":"https://example.de"}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, w.Code)
+	}
+}
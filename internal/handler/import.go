@@ -0,0 +1,438 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+	"github.com/advn1/url-shortener/internal/middleware"
+	"github.com/google/uuid"
+)
+
+// formats of imported files
+const (
+	ImportCSV    = "csv"
+	ImportNDJSON = "ndjson"
+)
+
+// ImportOptions describe who imported links belong to. Imports of an API key
+// are owned by its owner and may only use namespaces it is a member of. Other
+// imports, of the admin and of the command line, are owned by Owner
+type ImportOptions struct {
+	Format string
+	Owner  string
+	Key    *APIKey
+}
+
+// ImportRow reports a row that failed, or that was imported under another
+// short code because its alias was taken. Rows count data rows from 1
+type ImportRow struct {
+	Row      int    `json:"row"`
+	Alias    string `json:"alias,omitempty"`
+	ShortUrl string `json:"short_url,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Warning  string `json:"warning,omitempty"`
+}
+
+type ImportReport struct {
+	Imported int         `json:"imported"`
+	Failed   int         `json:"failed"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+// importRecord is a row of an imported file. Exports of other shorteners name
+// the columns differently, so a few common names are accepted for each
+type importRecord struct {
+	OriginalUrl string
+	Alias       string
+	CreatedAt   string
+	Tags        []string
+}
+
+// importColumns lists the accepted names of each column. When a row has
+// several names of the same column, the one listed first wins
+var importColumns = []struct {
+	name   string
+	column string
+}{
+	{"original_url", "original_url"}, {"url", "original_url"}, {"long_url", "original_url"}, {"longurl", "original_url"}, {"destination", "original_url"}, {"target", "original_url"},
+	{"alias", "alias"}, {"short_url", "alias"}, {"short_code", "alias"}, {"code", "alias"}, {"slug", "alias"}, {"keyword", "alias"}, {"back_half", "alias"},
+	{"created_at", "created_at"}, {"created", "created_at"}, {"date", "created_at"}, {"timestamp", "created_at"},
+	{"tags", "tags"}, {"labels", "tags"},
+}
+
+// importColumn returns the column of an accepted name and its precedence,
+// lower first
+func importColumn(name string) (column string, rank int, known bool) {
+	for i, accepted := range importColumns {
+		if accepted.name == name {
+			return accepted.column, i, true
+		}
+	}
+	return "", 0, false
+}
+
+// createdAtLayouts are the time formats accepted for created_at
+var createdAtLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+func parseCreatedAt(value string) (time.Time, error) {
+	if value == "" {
+		return time.Now().UTC(), nil
+	}
+	for _, layout := range createdAtLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("created_at %q is not a time like 2006-01-02T15:04:05Z", value)
+}
+
+func splitTags(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' || r == '|' })
+}
+
+// importReader reads rows of an imported file one by one. A row error is
+// returned with ok set, the stream continues after it. io.EOF ends the stream
+type importReader interface {
+	next() (record importRecord, ok bool, err error)
+}
+
+type csvImportReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVImportReader(r io.Reader) (*csvImportReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("CSV has no header row")
+		}
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	ranks := make(map[string]int)
+	for i, name := range header {
+		// spreadsheets may start the file with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		name = strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+		if column, rank, known := importColumn(name); known {
+			if seenRank, seen := ranks[column]; !seen || rank < seenRank {
+				columns[column] = i
+				ranks[column] = rank
+			}
+		}
+	}
+	if _, exists := columns["original_url"]; !exists {
+		return nil, errors.New("CSV header has no original_url column")
+	}
+	return &csvImportReader{reader: reader, columns: columns}, nil
+}
+
+func (c *csvImportReader) next() (importRecord, bool, error) {
+	row, err := c.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return importRecord{}, false, io.EOF
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		return importRecord{}, errors.As(err, &parseErr), err
+	}
+
+	field := func(column string) string {
+		if i, exists := c.columns[column]; exists && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	return importRecord{OriginalUrl: field("original_url"), Alias: field("alias"), CreatedAt: field("created_at"), Tags: splitTags(field("tags"))}, true, nil
+}
+
+type ndjsonImportReader struct {
+	scanner *bufio.Scanner
+}
+
+func newNDJSONImportReader(r io.Reader) *ndjsonImportReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &ndjsonImportReader{scanner: scanner}
+}
+
+func (n *ndjsonImportReader) next() (importRecord, bool, error) {
+	for n.scanner.Scan() {
+		line := n.scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return importRecord{}, true, err
+		}
+		// keys are matched regardless of case. of keys differing only in
+		// case, the one sorting first wins
+		names := slices.Sorted(maps.Keys(fields))
+		lowered := make(map[string]json.RawMessage, len(fields))
+		for _, name := range names {
+			if _, exists := lowered[strings.ToLower(name)]; !exists {
+				lowered[strings.ToLower(name)] = fields[name]
+			}
+		}
+
+		// the first non-empty name of each column in importColumns wins
+		var record importRecord
+		for _, accepted := range importColumns {
+			raw, exists := lowered[accepted.name]
+			if !exists {
+				continue
+			}
+			var target *string
+			switch accepted.column {
+			case "original_url":
+				target = &record.OriginalUrl
+			case "alias":
+				target = &record.Alias
+			case "created_at":
+				target = &record.CreatedAt
+			case "tags":
+				if len(record.Tags) > 0 {
+					continue
+				}
+				// an array, or a string of separated tags like in CSV
+				if err := json.Unmarshal(raw, &record.Tags); err != nil {
+					var tags string
+					if err := json.Unmarshal(raw, &tags); err != nil {
+						return importRecord{}, true, fmt.Errorf("%s must be an array of strings", accepted.name)
+					}
+					record.Tags = splitTags(tags)
+				}
+				continue
+			}
+			if *target == "" {
+				if err := json.Unmarshal(raw, target); err != nil {
+					return importRecord{}, true, fmt.Errorf("%s must be a string", accepted.name)
+				}
+			}
+		}
+		return record, true, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return importRecord{}, false, err
+	}
+	return importRecord{}, false, io.EOF
+}
+
+// importLink validates a row and makes the link it describes
+func (h *Handler) importLink(ctx context.Context, options ImportOptions, record importRecord) (*Link, error) {
+	if record.OriginalUrl == "" {
+		return nil, errors.New("original_url is empty")
+	}
+	originalUrl, err := h.URLPolicy.Normalize(record.OriginalUrl)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, err := parseCreatedAt(record.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := normalizeTags(record.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &Link{Uuid: uuid.New(), ShortUrl: GenerateRandomUrl(), OriginalUrl: originalUrl, Owner: options.Owner, CreatedAt: createdAt, Tags: tags}
+	if record.Alias != "" {
+		// exports often hold full short links
+		alias := record.Alias
+		if i := strings.Index(alias, "://"); i >= 0 {
+			_, alias, _ = strings.Cut(alias[i+3:], "/")
+		}
+		if err := validateAlias(alias); err != nil {
+			return nil, err
+		}
+		// imports for an owner follow the rules of the namespace, others of
+		// the admin or the command line only need it to exist
+		switch {
+		case options.Key != nil:
+			err = h.checkAlias(ctx, options.Key.Owner, alias)
+		case options.Owner != "":
+			err = h.checkAlias(ctx, options.Owner, alias)
+		default:
+			if name, _, namespaced := strings.Cut(alias, "/"); namespaced {
+				_, err = h.findNamespace(ctx, name)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		link.ShortUrl = alias
+	}
+	if options.Key != nil {
+		link.APIKeyID = options.Key.ID.String()
+		link.Owner = options.Key.Owner
+	}
+
+	if result, ok := h.screenLink(link); !ok {
+		return nil, errors.New("blocked URL: " + result.Reason)
+	}
+	return link, nil
+}
+
+// Import creates links from the rows of a CSV or JSON-lines stream. Rows are
+// imported on their own, a failed row is reported and the import goes on. An
+// alias that is taken is replaced with a random short code. In the database
+// mode the whole import is a single transaction, so an error reading the
+// stream imports nothing
+func (h *Handler) Import(ctx context.Context, r io.Reader, options ImportOptions) (*ImportReport, error) {
+	var rows importReader
+	switch options.Format {
+	case ImportCSV:
+		reader, err := newCSVImportReader(r)
+		if err != nil {
+			return nil, err
+		}
+		rows = reader
+	case ImportNDJSON:
+		rows = newNDJSONImportReader(r)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", options.Format)
+	}
+
+	save := h.saveLink
+	commit := func() error { return nil }
+	if h.dbConnection != nil {
+		tx, err := h.dbConnection.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+
+		// a savepoint per row keeps a failed insert from aborting the transaction
+		save = func(ctx context.Context, link *Link) error {
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT import_row"); err != nil {
+				return err
+			}
+			if err := insertLink(ctx, tx, link); err != nil {
+				if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row"); rollbackErr != nil {
+					return rollbackErr
+				}
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row")
+			return err
+		}
+		commit = tx.Commit
+	}
+
+	report := &ImportReport{Rows: make([]ImportRow, 0)}
+	for row := 1; ; row++ {
+		record, ok, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !ok {
+			return nil, err
+		}
+
+		var link *Link
+		if err == nil {
+			link, err = h.importLink(ctx, options, record)
+		}
+		if err == nil {
+			err = save(ctx, link)
+			if errors.Is(err, errLinkExists) && record.Alias != "" {
+				link.ShortUrl = GenerateRandomUrl()
+				err = save(ctx, link)
+				if err == nil {
+					report.Rows = append(report.Rows, ImportRow{Row: row, Alias: record.Alias, ShortUrl: link.ShortUrl, Warning: "alias is taken, imported under a new short URL"})
+				}
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			report.Failed++
+			report.Rows = append(report.Rows, ImportRow{Row: row, Alias: record.Alias, Error: err.Error()})
+			continue
+		}
+		report.Imported++
+	}
+
+	if err := commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// importFormat picks the format of an import from its Content-Type
+func importFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return ImportCSV
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+		return ImportNDJSON
+	}
+	return ""
+}
+
+// handler for bulk imports (POST) of CSV or JSON-lines files. API keys import
+// links they own, the admin imports links for the owner query parameter. The
+// body is streamed and has a limit of its own, MAX_IMPORT_BODY_SIZE
+func (h *Handler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleImport called", "path", r.URL.Path)
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+		return
+	}
+
+	options := ImportOptions{Format: importFormat(r.Header.Get("Content-Type"))}
+	if h.isAdmin(r) {
+		options.Owner = strings.TrimSpace(r.URL.Query().Get("owner"))
+	} else {
+		key, ok := h.requireScope(w, r, ScopeCreate)
+		if !ok {
+			return
+		}
+		options.Key = key
+	}
+
+	if options.Format == "" {
+		jsonutils.WriteJSONError(w, http.StatusUnsupportedMediaType, "Unsupported Content-Type", "import text/csv or application/x-ndjson")
+		return
+	}
+
+	report, err := h.Import(r.Context(), r.Body, options)
+	if err != nil {
+		if middleware.WriteBodyTooLarge(w, err) {
+			return
+		}
+		h.logger.Warnw("Import", "error", err)
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid import", err.Error())
+		return
+	}
+
+	h.logger.Infow("Import done", "imported", report.Imported, "failed", report.Failed)
+	json.NewEncoder(w).Encode(report)
+}
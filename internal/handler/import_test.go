@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func postImport(h *Handler, token string, contentType string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api/import", strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.HandleImport(w, r)
+	return w
+}
+
+func decodeImportReport(t *testing.T, w *httptest.ResponseRecorder) ImportReport {
+	t.Helper()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var report ImportReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("error on decoding import report: %v", err)
+	}
+	return report
+}
+
+func TestImport_CSV(t *testing.T) {
+	storagePath := t.TempDir() + "/storage.json"
+	h := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	h.AdminToken = "admin-secret"
+	key := createAPIKey(t, h, `{"owner":"marketing","scopes":["create","read-stats"]}`)
+	createLabeledLink(t, h, key.Key, `{"url":"https://example.com/existing","alias":"taken"}`)
+
+	csv := "Long URL,Slug,Created,Tags\n" +
+		"https://example.com/spring,spring,2023-03-01,\"sale, 2023\"\n" +
+		"javascript:alert(1),evil,,\n" +
+		"https://example.com/other,https://bit.ly/taken,2023-04-01T10:00:00Z,\n" +
+		"https://example.com/when,,yesterday,\n" +
+		"https://example.com/random,,,\n"
+	report := decodeImportReport(t, postImport(h, key.Key, "text/csv; charset=utf-8", csv))
+
+	if report.Imported != 3 || report.Failed != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(report.Rows) != 3 || report.Rows[0].Row != 2 || report.Rows[1].Row != 3 || report.Rows[1].Warning == "" || report.Rows[2].Row != 4 {
+		t.Errorf("unexpected rows: %+v", report.Rows)
+	}
+
+	reloaded := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading storage file: %v", err)
+	}
+	link, err := reloaded.findLink(t.Context(), "", "spring")
+	if err != nil {
+		t.Fatalf("imported alias is missing: %v", err)
+	}
+	if link.Owner != "marketing" || !link.CreatedAt.Equal(time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)) || strings.Join(link.Tags, ",") != "2023,sale" {
+		t.Errorf("unexpected imported link: %+v", link)
+	}
+	if location := visit(reloaded, "taken").Header().Get("Location"); location != "https://example.com/existing" {
+		t.Errorf("existing alias was overwritten, it redirects to %q", location)
+	}
+}
+
+func TestImport_NDJSON(t *testing.T) {
+	h := newAdminHandler(t)
+	key := createAPIKey(t, h, `{"owner":"docs","scopes":["create"]}`)
+	postNamespace(h, key.Key, `{"name":"docs"}`)
+
+	ndjson := `{"original_url":"https://example.com/guide","alias":"docs/guide","tags":["docs"]}` + "\n" +
+		"\n" +
+		`{"url":"https://example.com/team","alias":"team/page"}` + "\n" +
+		`{"original_url":` + "\n" +
+		`{"original_url":"https://example.com/faq","tags":"help|faq"}` + "\n"
+	report := decodeImportReport(t, postImport(h, key.Key, "application/x-ndjson", ndjson))
+
+	if report.Imported != 2 || report.Failed != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if location := visit(h, "docs/guide").Header().Get("Location"); location != "https://example.com/guide" {
+		t.Errorf("imported link redirects to %q", location)
+	}
+
+	if w := postImport(h, key.Key, "application/json", ndjson); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected %v status code, got %v", http.StatusUnsupportedMediaType, w.Code)
+	}
+	if w := postImport(h, key.Key, "text/csv", "alias,tags\nx,y\n"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, w.Code)
+	}
+
+	admin := decodeImportReport(t, postImport(h, h.AdminToken, "text/csv", "url\nhttps://example.com/admin\n"))
+	if admin.Imported != 1 {
+		t.Errorf("unexpected report of the admin: %+v", admin)
+	}
+}
+
+func TestImport_SynonymPrecedence(t *testing.T) {
+	ndjson := `{"long_url":"https://example.com/long","URL":"https://example.com/url","short_url":"https://sho.rt/b","alias":"a","labels":"x","tags":["y"]}` + "\n"
+	// run often enough that a random choice would show
+	for range 20 {
+		record, _, err := newNDJSONImportReader(strings.NewReader(ndjson)).next()
+		if err != nil {
+			t.Fatalf("error on reading row: %v", err)
+		}
+		if record.OriginalUrl != "https://example.com/url" || record.Alias != "a" || strings.Join(record.Tags, ",") != "y" {
+			t.Fatalf("synonyms resolved out of order: %+v", record)
+		}
+	}
+
+	rows, err := newCSVImportReader(strings.NewReader("Short URL,Long URL,Alias,URL\nhttps://sho.rt/b,https://example.com/long,a,https://example.com/url\n"))
+	if err != nil {
+		t.Fatalf("error on reading header: %v", err)
+	}
+	if record, _, err := rows.next(); err != nil || record.OriginalUrl != "https://example.com/url" || record.Alias != "a" {
+		t.Errorf("synonyms resolved out of order: %+v (%v)", record, err)
+	}
+}
+
+func TestImport_AdminNamespacedAliases(t *testing.T) {
+	h := newAdminHandler(t)
+	key := createAPIKey(t, h, `{"owner":"docs","scopes":["create"]}`)
+	postNamespace(h, key.Key, `{"name":"docs"}`)
+
+	ndjson := `{"url":"https://example.com/guide","alias":"docs/guide"}` + "\n" +
+		`{"url":"https://example.com/team","alias":"team/page"}` + "\n"
+	if report := decodeImportReport(t, postImport(h, h.AdminToken, "application/x-ndjson", ndjson)); report.Imported != 1 || report.Failed != 1 {
+		t.Errorf("admin import must need an existing namespace: %+v", report)
+	}
+
+	// imports for an owner only reach namespaces the owner is a member of
+	r := httptest.NewRequest("POST", "/api/import?owner=growth", strings.NewReader(`{"url":"https://example.com/faq","alias":"docs/faq"}`))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	r.Header.Set("Authorization", "Bearer "+h.AdminToken)
+	w := httptest.NewRecorder()
+	h.HandleImport(w, r)
+	if report := decodeImportReport(t, w); report.Imported != 0 || report.Failed != 1 {
+		t.Errorf("import for a non-member must fail: %+v", report)
+	}
+}
@@ -0,0 +1,349 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// migrations report progress every migrateProgressEvery records of a stage
+const migrateProgressEvery = 1000
+
+// MigrateOptions control a copy between storage backends. DryRun only counts
+// what would be copied. Progress, when set, is called during every stage and
+// once at its end
+type MigrateOptions struct {
+	DryRun   bool
+	Progress func(stage string, count MigrateCount)
+}
+
+// MigrateCount counts the records of a stage. Skipped records are already in
+// the destination, conflicting ones are held there by a different record
+type MigrateCount struct {
+	Copied    int `json:"copied"`
+	Skipped   int `json:"skipped"`
+	Conflicts int `json:"conflicts"`
+}
+
+type MigrateReport struct {
+	Domains    MigrateCount `json:"domains"`
+	Namespaces MigrateCount `json:"namespaces"`
+	APIKeys    MigrateCount `json:"api_keys"`
+	Links      MigrateCount `json:"links"`
+	Revisions  MigrateCount `json:"revisions"`
+	Clicks     MigrateCount `json:"clicks"`
+}
+
+// eachRevision calls fn for every revision, oldest first
+func (h *Handler) eachRevision(ctx context.Context, fn func(*Revision) error) error {
+	if h.dbConnection != nil {
+		rows, err := h.reader().QueryContext(ctx, `SELECT short_url, domain, original_url, replaced_at, COALESCE(replaced_by, '') FROM url_revisions
+			ORDER BY replaced_at, id`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var revision Revision
+			if err := rows.Scan(&revision.ShortUrl, &revision.Domain, &revision.OriginalUrl, &revision.ReplacedAt, &revision.ReplacedBy); err != nil {
+				return err
+			}
+			if err := fn(&revision); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+
+	h.mu.RLock()
+	revisions := make([]Revision, 0)
+	for _, linkRevisions := range h.revisions {
+		revisions = append(revisions, linkRevisions...)
+	}
+	h.mu.RUnlock()
+
+	slices.SortStableFunc(revisions, func(a, b Revision) int { return a.ReplacedAt.Compare(b.ReplacedAt) })
+	for i := range revisions {
+		if err := fn(&revisions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countHistory returns how many revisions and clicks of link are stored
+func (h *Handler) countHistory(ctx context.Context, link *Link) (revisions int, clicks int, err error) {
+	if h.dbConnection != nil {
+		err = h.dbConnection.QueryRowContext(ctx, `SELECT (SELECT count(*) FROM url_revisions WHERE domain = $1 AND short_url = $2),
+			(SELECT count(*) FROM clicks WHERE domain = $1 AND short_url = $2)`, link.Domain, link.ShortUrl).Scan(&revisions, &clicks)
+		return revisions, clicks, err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.revisions[link.key()]), int(h.clickCounts[link.key()]), nil
+}
+
+// restoreAPIKey stores a copied key as it is, revocation included. It
+// reports false when a key with the same ID or hash is already stored
+func (h *Handler) restoreAPIKey(ctx context.Context, key *APIKey) (bool, error) {
+	if h.dbConnection != nil {
+		result, err := h.dbConnection.ExecContext(ctx, `INSERT INTO api_keys (id, owner, key_hash, scopes, created_at, revoked_at) VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT DO NOTHING`, key.ID, key.Owner, key.Hash, strings.Join(key.Scopes, ","), key.CreatedAt, key.RevokedAt)
+		if err != nil {
+			return false, err
+		}
+		inserted, err := result.RowsAffected()
+		return inserted > 0, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.apiKeys[key.Hash]; exists {
+		return false, nil
+	}
+	if h.StoragePath != "" {
+		if err := h.appendRecord(fileRecord{Kind: recordAPIKey, APIKey: key}); err != nil {
+			return false, err
+		}
+	}
+	h.apiKeys[key.Hash] = key
+	return true, nil
+}
+
+// restoreRevision stores a copied revision of a link
+func (h *Handler) restoreRevision(ctx context.Context, revision *Revision) error {
+	if h.dbConnection != nil {
+		_, err := h.dbConnection.ExecContext(ctx, "INSERT INTO url_revisions (short_url, domain, original_url, replaced_at, replaced_by) VALUES ($1, $2, $3, $4, NULLIF($5, ''))",
+			revision.ShortUrl, revision.Domain, revision.OriginalUrl, revision.ReplacedAt, revision.ReplacedBy)
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.StoragePath != "" {
+		if err := h.appendRecord(fileRecord{Kind: recordRevision, Revision: revision}); err != nil {
+			return err
+		}
+	}
+	key := linkKey(revision.Domain, revision.ShortUrl)
+	h.revisions[key] = append(h.revisions[key], *revision)
+	return nil
+}
+
+// restoreClick stores a copied click and counts it for its link. Unlike
+// saveClick it ignores click limits, the click already happened
+func (h *Handler) restoreClick(ctx context.Context, click *Click) error {
+	if h.dbConnection != nil {
+		tx, err := h.dbConnection.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		_, err = tx.ExecContext(ctx, "UPDATE urls SET clicks = clicks + 1 WHERE domain = $1 AND short_url = $2", click.Domain, click.ShortUrl)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO clicks (short_url, domain, clicked_at, referer, user_agent, destination, country)
+			VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''))`,
+			click.ShortUrl, click.Domain, click.ClickedAt, click.Referer, click.UserAgent, click.Destination, click.Country)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.StoragePath != "" {
+		if err := h.appendRecord(fileRecord{Kind: recordClick, Click: click}); err != nil {
+			return err
+		}
+	}
+	h.countClick(click)
+	return nil
+}
+
+// MigrateTo copies every record of h into dst. Records already in dst are
+// skipped, so an interrupted migration can simply be run again. Revisions
+// and clicks have no identity of their own: they are copied oldest first, so
+// the ones a link already has in dst are its oldest, and only the newer ones
+// are copied. This holds while dst serves no traffic during the migration.
+// Revisions and clicks of a link held in dst by a different link are skipped
+func (h *Handler) MigrateTo(ctx context.Context, dst *Handler, options MigrateOptions) (*MigrateReport, error) {
+	report := &MigrateReport{}
+	progress := func(stage string, count *MigrateCount, done bool) {
+		total := count.Copied + count.Skipped + count.Conflicts
+		if options.Progress != nil && (done || total%migrateProgressEvery == 0) {
+			options.Progress(stage, *count)
+		}
+	}
+
+	// domains, namespaces and keys come first, links refer to them
+	domains, err := h.listDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, domain := range domains {
+		if options.DryRun {
+			var exists bool
+			if exists, err = dst.domainExists(ctx, domain.Name); err == nil && exists {
+				err = errDomainExists
+			}
+		} else {
+			err = dst.saveDomain(ctx, domain)
+		}
+		switch {
+		case errors.Is(err, errDomainExists):
+			report.Domains.Skipped++
+		case err != nil:
+			return nil, err
+		default:
+			report.Domains.Copied++
+		}
+		progress("domains", &report.Domains, false)
+	}
+	progress("domains", &report.Domains, true)
+
+	namespaces, err := h.listNamespaces(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, namespace := range namespaces {
+		if options.DryRun {
+			if _, err = dst.findNamespace(ctx, namespace.Name); err == nil {
+				err = errNamespaceExists
+			} else if errors.Is(err, errNamespaceNotFound) {
+				err = nil
+			}
+		} else {
+			err = dst.saveNamespace(ctx, namespace, false)
+		}
+		switch {
+		case errors.Is(err, errNamespaceExists):
+			report.Namespaces.Skipped++
+		case err != nil:
+			return nil, err
+		default:
+			report.Namespaces.Copied++
+		}
+		progress("namespaces", &report.Namespaces, false)
+	}
+	progress("namespaces", &report.Namespaces, true)
+
+	keys, err := h.listAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		copied := false
+		if options.DryRun {
+			if _, err = dst.findAPIKey(ctx, key.Hash); errors.Is(err, errInvalidAPIKey) {
+				copied, err = true, nil
+			}
+		} else {
+			copied, err = dst.restoreAPIKey(ctx, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if copied {
+			report.APIKeys.Copied++
+		} else {
+			report.APIKeys.Skipped++
+		}
+		progress("api keys", &report.APIKeys, false)
+	}
+	progress("api keys", &report.APIKeys, true)
+
+	// revisions and clicks dst already has of each link both share
+	type linkHistory struct{ revisions, clicks int }
+	present := make(map[string]*linkHistory)
+	err = h.eachLink(ctx, ExportFilter{}, func(link *Link) error {
+		existing, err := dst.findLink(ctx, link.Domain, link.ShortUrl)
+		switch {
+		case err == nil && existing.Uuid == link.Uuid:
+			revisions, clicks, err := dst.countHistory(ctx, link)
+			if err != nil {
+				return err
+			}
+			present[link.key()] = &linkHistory{revisions: revisions, clicks: clicks}
+			report.Links.Skipped++
+		case err == nil:
+			report.Links.Conflicts++
+		case !errors.Is(err, errLinkNotFound):
+			return err
+		default:
+			if !options.DryRun {
+				copied := *link
+				if err := dst.saveLink(ctx, &copied); err != nil {
+					return err
+				}
+			}
+			present[link.key()] = &linkHistory{}
+			report.Links.Copied++
+		}
+		progress("links", &report.Links, false)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	progress("links", &report.Links, true)
+
+	err = h.eachRevision(ctx, func(revision *Revision) error {
+		history := present[linkKey(revision.Domain, revision.ShortUrl)]
+		switch {
+		case history == nil:
+			report.Revisions.Skipped++
+		case history.revisions > 0:
+			history.revisions--
+			report.Revisions.Skipped++
+		default:
+			if !options.DryRun {
+				if err := dst.restoreRevision(ctx, revision); err != nil {
+					return err
+				}
+			}
+			report.Revisions.Copied++
+		}
+		progress("revisions", &report.Revisions, false)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	progress("revisions", &report.Revisions, true)
+
+	err = h.eachClick(ctx, ExportFilter{}, func(click *Click) error {
+		history := present[linkKey(click.Domain, click.ShortUrl)]
+		switch {
+		case history == nil:
+			report.Clicks.Skipped++
+		case history.clicks > 0:
+			history.clicks--
+			report.Clicks.Skipped++
+		default:
+			if !options.DryRun {
+				if err := dst.restoreClick(ctx, click); err != nil {
+					return err
+				}
+			}
+			report.Clicks.Copied++
+		}
+		progress("clicks", &report.Clicks, false)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	progress("clicks", &report.Clicks, true)
+
+	return report, nil
+}
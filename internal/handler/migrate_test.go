@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMigrateTo_CopiesOnce(t *testing.T) {
+	dir := t.TempDir()
+	src := New("http://localhost:8080", make(map[string]string), dir+"/src.json", nil, zap.NewNop().Sugar())
+	src.AdminToken = "admin-secret"
+
+	registerDomain(t, src, "go.brand.com")
+	key := createAPIKey(t, src, `{"owner":"docs","scopes":["create","read-stats"]}`)
+	postNamespace(src, key.Key, `{"name":"docs"}`)
+	createLabeledLink(t, src, key.Key, `{"url":"https://example.com/guide","alias":"docs/guide","tags":["docs"]}`)
+	id := createOwnedLink(t, src, key.Key, "https://example.com/typo")
+	patchURL(src, id, key.Key, `{"original_url":"https://example.com/fixed"}`)
+	visit(src, id)
+	visit(src, id)
+
+	dstPath := dir + "/dst.json"
+	dst := New("http://localhost:8080", make(map[string]string), dstPath, nil, zap.NewNop().Sugar())
+	dry, err := src.MigrateTo(t.Context(), dst, MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("error on dry run: %v", err)
+	}
+	if dry.Links.Copied != 2 || dry.Clicks.Copied != 2 {
+		t.Errorf("unexpected dry run report: %+v", dry)
+	}
+	if _, err := os.Stat(dstPath); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote the destination")
+	}
+
+	var stages []string
+	report, err := src.MigrateTo(t.Context(), dst, MigrateOptions{Progress: func(stage string, count MigrateCount) { stages = append(stages, stage) }})
+	if err != nil {
+		t.Fatalf("error on migration: %v", err)
+	}
+	want := MigrateReport{Domains: MigrateCount{Copied: 1}, Namespaces: MigrateCount{Copied: 1}, APIKeys: MigrateCount{Copied: 1}, Links: MigrateCount{Copied: 2},
+		Revisions: MigrateCount{Copied: 1}, Clicks: MigrateCount{Copied: 2}}
+	if *report != want {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if len(stages) != 6 {
+		t.Errorf("unexpected progress: %v", stages)
+	}
+
+	again, err := src.MigrateTo(t.Context(), dst, MigrateOptions{})
+	if err != nil {
+		t.Fatalf("error on second migration: %v", err)
+	}
+	if again.Links.Copied != 0 || again.Links.Skipped != 2 || again.Clicks.Copied != 0 || again.APIKeys.Skipped != 1 {
+		t.Errorf("second migration copied again: %+v", again)
+	}
+
+	reloaded := New("http://localhost:8080", make(map[string]string), dstPath, nil, zap.NewNop().Sugar())
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading migrated file: %v", err)
+	}
+	if info := getURLInfo(t, reloaded, id, key.Key); info.OriginalUrl != "https://example.com/fixed" || info.Clicks == nil || *info.Clicks != 2 {
+		t.Errorf("unexpected migrated link: %+v", info)
+	}
+	if history := getHistory(t, reloaded, id, key.Key); len(history.Revisions) != 1 {
+		t.Errorf("unexpected migrated history: %+v", history)
+	}
+	if w := shortenAlias(reloaded, key.Key, "docs/faq"); w.Code != 201 {
+		t.Errorf("migrated key or namespace doesn't work: %v %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMigrateTo_ResumesInterruptedClicks(t *testing.T) {
+	dir := t.TempDir()
+	src := New("http://localhost:8080", make(map[string]string), dir+"/src.json", nil, zap.NewNop().Sugar())
+	id := createLink(t, src, `{"url":"https://example.com/popular"}`)
+	patchedAt := time.Now().UTC()
+	if err := src.restoreRevision(t.Context(), &Revision{ShortUrl: id, OriginalUrl: "https://example.com/old", ReplacedAt: patchedAt}); err != nil {
+		t.Fatalf("error on storing revision: %v", err)
+	}
+	for i := range 1500 {
+		click := &Click{ShortUrl: id, ClickedAt: patchedAt.Add(time.Duration(i) * time.Second), Referer: strconv.Itoa(i)}
+		if err := src.restoreClick(t.Context(), click); err != nil {
+			t.Fatalf("error on storing click: %v", err)
+		}
+	}
+
+	// the first run dies once a thousand clicks are copied
+	dstPath := dir + "/dst.json"
+	dst := New("http://localhost:8080", make(map[string]string), dstPath, nil, zap.NewNop().Sugar())
+	func() {
+		defer func() { recover() }()
+		src.MigrateTo(t.Context(), dst, MigrateOptions{Progress: func(stage string, count MigrateCount) {
+			if stage == "clicks" && count.Copied == 1000 {
+				panic("interrupted")
+			}
+		}})
+	}()
+	if dst.clickCounts[id] != 1000 {
+		t.Fatalf("expected the first run to stop after 1000 clicks, got %v", dst.clickCounts[id])
+	}
+
+	report, err := src.MigrateTo(t.Context(), dst, MigrateOptions{})
+	if err != nil {
+		t.Fatalf("error on second migration: %v", err)
+	}
+	if report.Links.Skipped != 1 || report.Revisions.Skipped != 1 || report.Clicks.Skipped != 1000 || report.Clicks.Copied != 500 {
+		t.Errorf("unexpected report of the second run: %+v", report)
+	}
+
+	reloaded := New("http://localhost:8080", make(map[string]string), dstPath, nil, zap.NewNop().Sugar())
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading migrated file: %v", err)
+	}
+	clicks := storedClicks(t, reloaded)
+	if len(clicks) != 1500 || len(reloaded.revisions[id]) != 1 {
+		t.Fatalf("expected 1500 clicks and a revision, got %v and %v", len(clicks), len(reloaded.revisions[id]))
+	}
+	for i, click := range clicks {
+		if click.Referer != strconv.Itoa(i) {
+			t.Fatalf("click %d was copied out of order or twice: %+v", i, click)
+		}
+	}
+}
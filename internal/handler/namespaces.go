@@ -0,0 +1,418 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+	"github.com/advn1/url-shortener/internal/middleware"
+)
+
+// Namespace is the first segment of /{team}/{code} links. Only its owner and
+// members may create links inside it, and only with aliases matching AliasPattern
+type Namespace struct {
+	Name         string    `json:"name"`
+	Owner        string    `json:"owner"`
+	Members      []string  `json:"members"`
+	AliasPattern string    `json:"alias_pattern,omitempty"` // regular expression matched against the whole code
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type PostNamespaceBody struct {
+	Name         string   `json:"name"`
+	Owner        string   `json:"owner,omitempty"` // admin only. defaults to the owner of the API key
+	Members      []string `json:"members,omitempty"`
+	AliasPattern string   `json:"alias_pattern,omitempty"`
+}
+
+// PatchNamespaceBody changes the fields that are set
+type PatchNamespaceBody struct {
+	Members      *[]string `json:"members,omitempty"`
+	AliasPattern *string   `json:"alias_pattern,omitempty"`
+}
+
+var (
+	errNamespaceNotFound  = errors.New("namespace not found")
+	errNamespaceExists    = errors.New("namespace is already taken")
+	errNotNamespaceMember = errors.New("only members of the namespace can create links in it")
+	errAliasPattern       = errors.New("alias doesn't match the pattern of the namespace")
+
+	namespaceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,31}$`)
+	aliasPattern         = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}(/[A-Za-z0-9_-]{1,64})?$`)
+
+	// first path segments used by other routes
+	reservedNames = []string{"api", "ping"}
+)
+
+// validateAlias checks the format of a custom short code, "code" or "namespace/code"
+func validateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return fmt.Errorf("alias must be 1 to 64 letters, digits, '-' or '_', optionally prefixed by a namespace and '/'")
+	}
+	first, code, namespaced := strings.Cut(alias, "/")
+	if slices.Contains(reservedNames, strings.ToLower(first)) {
+		return fmt.Errorf("alias %q is reserved", first)
+	}
+	// /{id}/qr serves QR codes
+	if namespaced && code == "qr" {
+		return fmt.Errorf("alias %q is reserved", code)
+	}
+	return nil
+}
+
+func compileAliasPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+func (n *Namespace) isMember(owner string) bool {
+	return owner != "" && (owner == n.Owner || slices.Contains(n.Members, owner))
+}
+
+func (n *Namespace) allowsAlias(code string) bool {
+	re, err := compileAliasPattern(n.AliasPattern)
+	if err != nil {
+		return false
+	}
+	return re == nil || re.MatchString(code)
+}
+
+// checkAlias checks that owner may create a link with the alias
+func (h *Handler) checkAlias(ctx context.Context, owner string, alias string) error {
+	name, code, namespaced := strings.Cut(alias, "/")
+	if !namespaced {
+		return nil
+	}
+
+	namespace, err := h.findNamespace(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !namespace.isMember(owner) {
+		return errNotNamespaceMember
+	}
+	if !namespace.allowsAlias(code) {
+		return fmt.Errorf("%w: %s", errAliasPattern, namespace.AliasPattern)
+	}
+	return nil
+}
+
+// authorizeAlias checks that key may create a link with the alias. It writes
+// the error response and returns false when it may not
+func (h *Handler) authorizeAlias(w http.ResponseWriter, r *http.Request, key *APIKey, alias string) bool {
+	if !strings.Contains(alias, "/") {
+		return true
+	}
+	if key == nil {
+		jsonutils.WriteJSONError(w, http.StatusUnauthorized, "Unauthorized", "API key required for namespaced aliases")
+		return false
+	}
+
+	err := h.checkAlias(r.Context(), key.Owner, alias)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errNamespaceNotFound):
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Unknown namespace", err.Error())
+	case errors.Is(err, errNotNamespaceMember):
+		jsonutils.WriteJSONError(w, http.StatusForbidden, "Forbidden", err.Error())
+	case errors.Is(err, errAliasPattern):
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid alias", err.Error())
+	default:
+		h.logger.Errorw("Namespace lookup", "error", err, "alias", alias)
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+	}
+	return false
+}
+
+const namespaceColumns = "name, owner, members, COALESCE(alias_pattern, ''), created_at"
+
+func scanNamespace(row rowScanner) (*Namespace, error) {
+	var namespace Namespace
+	var members string
+	err := row.Scan(&namespace.Name, &namespace.Owner, &members, &namespace.AliasPattern, &namespace.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errNamespaceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	namespace.Members = make([]string, 0)
+	if members != "" {
+		namespace.Members = strings.Split(members, ",")
+	}
+	return &namespace, nil
+}
+
+func (h *Handler) findNamespace(ctx context.Context, name string) (*Namespace, error) {
+	if h.dbConnection != nil {
+		return scanNamespace(h.dbConnection.QueryRowContext(ctx, "SELECT "+namespaceColumns+" FROM namespaces WHERE name = $1", name))
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	namespace, exists := h.namespaces[name]
+	if !exists {
+		return nil, errNamespaceNotFound
+	}
+	found := *namespace
+	return &found, nil
+}
+
+// listNamespaces returns the namespaces owner belongs to, or all of them when owner is empty
+func (h *Handler) listNamespaces(ctx context.Context, owner string) ([]*Namespace, error) {
+	namespaces := make([]*Namespace, 0)
+
+	if h.dbConnection != nil {
+		rows, err := h.reader().QueryContext(ctx, "SELECT "+namespaceColumns+" FROM namespaces ORDER BY name")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			namespace, err := scanNamespace(rows)
+			if err != nil {
+				return nil, err
+			}
+			if owner == "" || namespace.isMember(owner) {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+		return namespaces, rows.Err()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, namespace := range h.namespaces {
+		if owner == "" || namespace.isMember(owner) {
+			found := *namespace
+			namespaces = append(namespaces, &found)
+		}
+	}
+	slices.SortFunc(namespaces, func(a, b *Namespace) int { return strings.Compare(a.Name, b.Name) })
+	return namespaces, nil
+}
+
+// saveNamespace stores a new namespace, or an updated one when update is set
+func (h *Handler) saveNamespace(ctx context.Context, namespace *Namespace, update bool) error {
+	if h.dbConnection != nil {
+		members := strings.Join(namespace.Members, ",")
+		if update {
+			_, err := h.dbConnection.ExecContext(ctx, "UPDATE namespaces SET members = $2, alias_pattern = NULLIF($3, '') WHERE name = $1",
+				namespace.Name, members, namespace.AliasPattern)
+			return err
+		}
+
+		result, err := h.dbConnection.ExecContext(ctx, `INSERT INTO namespaces (name, owner, members, alias_pattern, created_at)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5) ON CONFLICT (name) DO NOTHING`,
+			namespace.Name, namespace.Owner, members, namespace.AliasPattern, namespace.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if inserted, err := result.RowsAffected(); err == nil && inserted == 0 {
+			return errNamespaceExists
+		}
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.namespaces[namespace.Name]; exists && !update {
+		return errNamespaceExists
+	}
+	if h.StoragePath != "" {
+		if err := h.appendRecord(fileRecord{Kind: recordNamespace, Namespace: namespace}); err != nil {
+			return err
+		}
+	}
+	h.namespaces[namespace.Name] = namespace
+	return nil
+}
+
+// namespaceCaller authenticates a namespace request. It returns the owner of
+// the API key, or admin set for the admin token
+func (h *Handler) namespaceCaller(w http.ResponseWriter, r *http.Request) (owner string, admin bool, ok bool) {
+	if h.isAdmin(r) {
+		return "", true, true
+	}
+	key, ok := h.requireScope(w, r, ScopeCreate)
+	if !ok {
+		return "", false, false
+	}
+	return key.Owner, false, true
+}
+
+func normalizeMembers(members []string) []string {
+	normalized := make([]string, 0, len(members))
+	for _, member := range members {
+		if member = strings.TrimSpace(member); member != "" && !strings.Contains(member, ",") {
+			normalized = append(normalized, member)
+		}
+	}
+	return slices.Compact(slices.Sorted(slices.Values(normalized)))
+}
+
+// handler for listing (GET) and claiming (POST) namespaces. API keys see and
+// claim their own namespaces, the admin sees all and claims them for anyone
+func (h *Handler) HandleNamespaces(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleNamespaces called", "path", r.URL.Path, "method", r.Method)
+
+	w.Header().Set("Content-Type", "application/json")
+	owner, admin, ok := h.namespaceCaller(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		namespaces, err := h.listNamespaces(r.Context(), owner)
+		if err != nil {
+			h.logger.Errorw("List namespaces", "error", err)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+			return
+		}
+		json.NewEncoder(w).Encode(namespaces)
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			if middleware.WriteBodyTooLarge(w, err) {
+				return
+			}
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Failed to read request body", "failed to read request body")
+			return
+		}
+
+		var postNamespaceBody PostNamespaceBody
+		if err := json.Unmarshal(body, &postNamespaceBody); err != nil {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid JSON format", "")
+			return
+		}
+
+		name := strings.ToLower(strings.TrimSpace(postNamespaceBody.Name))
+		if !namespaceNamePattern.MatchString(name) || slices.Contains(reservedNames, name) {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid name", "namespace must be 2 to 32 lowercase letters, digits, '-' or '_' and not reserved")
+			return
+		}
+		if admin {
+			owner = strings.TrimSpace(postNamespaceBody.Owner)
+			if owner == "" {
+				jsonutils.WriteJSONError(w, http.StatusBadRequest, "Empty owner", "owner of the namespace must be set")
+				return
+			}
+		}
+		if _, err := compileAliasPattern(postNamespaceBody.AliasPattern); err != nil {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid alias pattern", err.Error())
+			return
+		}
+
+		namespace := &Namespace{
+			Name:         name,
+			Owner:        owner,
+			Members:      normalizeMembers(postNamespaceBody.Members),
+			AliasPattern: postNamespaceBody.AliasPattern,
+			CreatedAt:    time.Now().UTC(),
+		}
+		if err := h.saveNamespace(r.Context(), namespace, false); err != nil {
+			if errors.Is(err, errNamespaceExists) {
+				jsonutils.WriteJSONError(w, http.StatusConflict, "Namespace taken", err.Error())
+				return
+			}
+			h.logger.Errorw("Save namespace", "error", err)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "cannot save namespace")
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(namespace)
+	default:
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+	}
+}
+
+// handler for a single namespace. GET is open to members, PATCH changes
+// members and the alias pattern and is only open to the owner
+func (h *Handler) HandleNamespaceByName(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleNamespaceByName called", "path", r.URL.Path, "method", r.Method)
+
+	w.Header().Set("Content-Type", "application/json")
+	owner, admin, ok := h.namespaceCaller(w, r)
+	if !ok {
+		return
+	}
+
+	name := r.PathValue("name")
+	namespace, err := h.findNamespace(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, errNamespaceNotFound) {
+			jsonutils.WriteJSONError(w, http.StatusNotFound, "Non existing namespace", err.Error())
+			return
+		}
+		h.logger.Errorw("Namespace lookup", "error", err, "namespace", name)
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !admin && !namespace.isMember(owner) {
+			jsonutils.WriteJSONError(w, http.StatusForbidden, "Forbidden", "only members can see the namespace")
+			return
+		}
+		json.NewEncoder(w).Encode(namespace)
+	case http.MethodPatch:
+		if !admin && owner != namespace.Owner {
+			jsonutils.WriteJSONError(w, http.StatusForbidden, "Forbidden", "only the owner can change the namespace")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			if middleware.WriteBodyTooLarge(w, err) {
+				return
+			}
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Failed to read request body", "failed to read request body")
+			return
+		}
+
+		var patchNamespaceBody PatchNamespaceBody
+		if err := json.Unmarshal(body, &patchNamespaceBody); err != nil {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid JSON format", "")
+			return
+		}
+
+		if patchNamespaceBody.Members != nil {
+			namespace.Members = normalizeMembers(*patchNamespaceBody.Members)
+		}
+		if patchNamespaceBody.AliasPattern != nil {
+			if _, err := compileAliasPattern(*patchNamespaceBody.AliasPattern); err != nil {
+				jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid alias pattern", err.Error())
+				return
+			}
+			namespace.AliasPattern = *patchNamespaceBody.AliasPattern
+		}
+
+		if err := h.saveNamespace(r.Context(), namespace, true); err != nil {
+			h.logger.Errorw("Save namespace", "error", err)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "cannot save namespace")
+			return
+		}
+		json.NewEncoder(w).Encode(namespace)
+	default:
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func postNamespace(h *Handler, token string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api/namespaces", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.HandleNamespaces(w, r)
+	return w
+}
+
+func shortenAlias(h *Handler, key string, alias string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"https://example.com/`+alias+`","alias":"`+alias+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	h.HandlePostRESTApi(w, r)
+	return w
+}
+
+func TestNamespaces_MembersCreateMatchingAliases(t *testing.T) {
+	storagePath := t.TempDir() + "/storage.json"
+	h := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	h.AdminToken = "admin-secret"
+
+	owner := createAPIKey(t, h, `{"owner":"docs","scopes":["create"]}`)
+	member := createAPIKey(t, h, `{"owner":"support","scopes":["create"]}`)
+	other := createAPIKey(t, h, `{"owner":"growth","scopes":["create"]}`)
+
+	if w := postNamespace(h, owner.Key, `{"name":"docs","members":["support"],"alias_pattern":"[a-z-]+"}`); w.Code != http.StatusCreated {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if w := postNamespace(h, other.Key, `{"name":"docs"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected %v status code, got %v", http.StatusConflict, w.Code)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{name: "docs/onboarding", key: owner.Key, want: http.StatusCreated},
+		{name: "docs/faq", key: member.Key, want: http.StatusCreated},
+		{name: "docs/faq", key: owner.Key, want: http.StatusConflict},
+		{name: "docs/Upper_Case", key: owner.Key, want: http.StatusBadRequest},
+		{name: "docs/pricing", key: other.Key, want: http.StatusForbidden},
+		{name: "docs/pricing", key: "", want: http.StatusUnauthorized},
+		{name: "missing/pricing", key: owner.Key, want: http.StatusBadRequest},
+		{name: "docs/qr", key: owner.Key, want: http.StatusBadRequest},
+		{name: "api/pricing", key: owner.Key, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := shortenAlias(h, tt.key, tt.name); w.Code != tt.want {
+			t.Errorf("%s: expected %v status code, got %v: %s", tt.name, tt.want, w.Code, w.Body.String())
+		}
+	}
+
+	reloaded := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading storage file: %v", err)
+	}
+
+	if location := visit(reloaded, "docs/onboarding").Header().Get("Location"); location != "https://example.com/docs/onboarding" {
+		t.Errorf("namespaced link redirects to %q", location)
+	}
+	if w := shortenAlias(reloaded, member.Key, "docs/faq"); w.Code != http.StatusConflict {
+		t.Errorf("expected %v status code after reload, got %v", http.StatusConflict, w.Code)
+	}
+}
+
+func TestNamespaces_OwnerUpdatesMembers(t *testing.T) {
+	h := newAdminHandler(t)
+
+	owner := createAPIKey(t, h, `{"owner":"docs","scopes":["create"]}`)
+	member := createAPIKey(t, h, `{"owner":"support","scopes":["create"]}`)
+	postNamespace(h, owner.Key, `{"name":"docs"}`)
+
+	patch := func(key string, body string) int {
+		r := httptest.NewRequest("PATCH", "/api/namespaces/docs", strings.NewReader(body))
+		r.SetPathValue("name", "docs")
+		r.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		h.HandleNamespaceByName(w, r)
+		return w.Code
+	}
+
+	if code := patch(member.Key, `{"members":["support"]}`); code != http.StatusForbidden {
+		t.Errorf("expected %v status code, got %v", http.StatusForbidden, code)
+	}
+	if code := patch(owner.Key, `{"alias_pattern":"("}`); code != http.StatusBadRequest {
+		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, code)
+	}
+	if code := patch(owner.Key, `{"members":["support"]}`); code != http.StatusOK {
+		t.Errorf("expected %v status code, got %v", http.StatusOK, code)
+	}
+
+	if w := shortenAlias(h, member.Key, "docs/faq"); w.Code != http.StatusCreated {
+		t.Errorf("expected %v status code, got %v: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+}
+
+func TestAlias_TopLevel(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+
+	if w := shortenAlias(h, "", "launch"); w.Code != http.StatusCreated {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if w := shortenAlias(h, "", "launch"); w.Code != http.StatusConflict {
+		t.Errorf("expected %v status code, got %v", http.StatusConflict, w.Code)
+	}
+	if w := shortenAlias(h, "", "ping"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, w.Code)
+	}
+	if location := visit(h, "launch").Header().Get("Location"); location != "https://example.com/launch" {
+		t.Errorf("alias redirects to %q", location)
+	}
+}
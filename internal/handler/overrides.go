@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// URLOverrides maps a key, such as a platform or a country, to the destination
+// used instead of the original URL. It is stored as a JSON object
+type URLOverrides map[string]string
+
+// Value implements driver.Valuer. An empty set is stored as NULL
+func (o URLOverrides) Value() (driver.Value, error) {
+	if len(o) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (o *URLOverrides) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*o = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, o)
+	case string:
+		return json.Unmarshal([]byte(src), o)
+	}
+	return fmt.Errorf("cannot scan %T into URLOverrides", src)
+}
+
+// normalizeOverrides normalizes every override with the URL policy
+func (h *Handler) normalizeOverrides(overrides URLOverrides) (URLOverrides, error) {
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+
+	normalized := make(URLOverrides, len(overrides))
+	for key, rawURL := range overrides {
+		destination, err := h.URLPolicy.Normalize(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		normalized[key] = destination
+	}
+	return normalized, nil
+}
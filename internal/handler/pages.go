@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// HTML pages shown instead of a redirect
+
+var blockedPage = template.Must(template.New("blocked").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Link blocked</title></head>
+<body>
+<h1>This link has been blocked</h1>
+<p>The short link <code>{{.ShortUrl}}</code> points to a destination that was reported as harmful ({{.Reason}}).</p>
+<p>For your safety you are not being redirected to <code>{{.Destination}}</code>.</p>
+</body>
+</html>
+`))
+
+func renderBlockedPage(w http.ResponseWriter, link *Link, destination string, reason string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	blockedPage.Execute(w, map[string]string{
+		"ShortUrl":    link.ShortUrl,
+		"Destination": destination,
+		"Reason":      reason,
+	})
+}
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Link preview</title></head>
+<body>
+<h1>Where does this link go?</h1>
+<p>The short link <code>{{.ShortLink}}</code> redirects to:</p>
+<p><a href="{{.Destination}}" rel="noopener noreferrer nofollow"><code>{{.Destination}}</code></a></p>
+{{if .Warning}}<p><strong>Warning:</strong> this link is under review ({{.Warning}}). Only continue if you trust its source.</p>
+{{end}}{{if .ExpiresAt}}<p>This link expires on {{.ExpiresAt}}.</p>
+{{end}}</body>
+</html>
+`))
+
+// renderPreviewPage shows the destination of a link without following it
+func renderPreviewPage(w http.ResponseWriter, shortLink string, link *Link, destination string) {
+	data := map[string]string{
+		"ShortLink":   shortLink,
+		"Destination": destination,
+	}
+	if link.Flagged {
+		data["Warning"] = link.FlagReason
+	}
+	if link.NotAfter != nil {
+		data["ExpiresAt"] = link.NotAfter.UTC().Format(time.RFC1123)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	previewPage.Execute(w, data)
+}
+
+var passwordPage = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Password required</title></head>
+<body>
+<h1>This link is password protected</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>
+{{end}}<form method="post" action="{{.Action}}">
+<label>Password <input type="password" name="password" autocomplete="current-password" autofocus required></label>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// renderPasswordPage asks for the password of a protected link. The
+// destination is never part of the page. The query of the visit is posted
+// back, so links forwarding it keep working
+func renderPasswordPage(w http.ResponseWriter, shortLink string, rawQuery string, status int, message string) {
+	action := shortLink
+	if rawQuery != "" {
+		action += "?" + rawQuery
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	passwordPage.Execute(w, map[string]string{
+		"Action": action,
+		"Error":  message,
+	})
+}
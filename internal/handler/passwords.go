@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt ignores everything after the first 72 bytes
+const maxLinkPasswordLength = 72
+
+func hashLinkPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// attemptLimiter counts failed password attempts per key in fixed windows
+type attemptLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]*attemptWindow
+}
+
+type attemptWindow struct {
+	failures int
+	start    time.Time
+}
+
+// allow reports whether another attempt is allowed and, if not, how long
+// the caller has to wait
+func (l *attemptLimiter) allow(key string, limit int, window time.Duration, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	attempt := l.attempts[key]
+	if attempt == nil || now.Sub(attempt.start) >= window {
+		return 0, true
+	}
+	if attempt.failures < limit {
+		return 0, true
+	}
+	return attempt.start.Add(window).Sub(now), false
+}
+
+func (l *attemptLimiter) fail(key string, window time.Duration, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.attempts == nil {
+		l.attempts = make(map[string]*attemptWindow)
+	}
+	// drop finished windows once in a while so the map does not grow forever
+	if len(l.attempts) >= 1024 {
+		for k, attempt := range l.attempts {
+			if now.Sub(attempt.start) >= window {
+				delete(l.attempts, k)
+			}
+		}
+	}
+
+	attempt := l.attempts[key]
+	if attempt == nil || now.Sub(attempt.start) >= window {
+		attempt = &attemptWindow{start: now}
+		l.attempts[key] = attempt
+	}
+	attempt.failures++
+}
+
+func (l *attemptLimiter) reset(key string) {
+	l.mu.Lock()
+	delete(l.attempts, key)
+	l.mu.Unlock()
+}
+
+// servePasswordProtected shows the password form for a protected link and
+// redirects once the posted password matches
+func (h *Handler) servePasswordProtected(w http.ResponseWriter, r *http.Request, link *Link, destination string, sticky *http.Cookie, country string) {
+	if r.Method != http.MethodPost {
+		renderPasswordPage(w, h.linkURL(link), r.URL.RawQuery, http.StatusOK, "")
+		return
+	}
+
+	now := time.Now()
+	key := link.key() + "|" + h.clientIP(r)
+	if retryAfter, ok := h.passwordAttempts.allow(key, h.PasswordMaxAttempts, h.PasswordAttemptWindow, now); !ok {
+		h.logger.Warnw("Too many password attempts", "id", link.ShortUrl, "client", h.clientIP(r))
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		renderPasswordPage(w, h.linkURL(link), r.URL.RawQuery, http.StatusTooManyRequests, "Too many attempts. Try again later.")
+		return
+	}
+
+	password := r.PostFormValue("password")
+	if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+		h.passwordAttempts.fail(key, h.PasswordAttemptWindow, now)
+		renderPasswordPage(w, h.linkURL(link), r.URL.RawQuery, http.StatusForbidden, "Incorrect password.")
+		return
+	}
+	h.passwordAttempts.reset(key)
+
+	if h.serveBlocked(w, link, destination) {
+		return
+	}
+	if err := h.recordClick(r, link, destination, country); err != nil {
+		h.writeClickError(w, err, link)
+		return
+	}
+	if sticky != nil {
+		http.SetCookie(w, sticky)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, link.withQuery(destination, r.URL.RawQuery), http.StatusSeeOther)
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func postPassword(h *Handler, id string, password string) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}}
+	r := httptest.NewRequest("POST", "/"+id, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.HandleGetById(w, r)
+	return w
+}
+
+func TestPasswordProtectedLink(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+	id := createLink(t, h, `{"url":"https://docs.example.com/internal","password":"hunter2"}`)
+
+	w := visit(h, id)
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Fatalf("expected password form, got %v redirecting to %q", w.Code, w.Header().Get("Location"))
+	}
+	if strings.Contains(w.Body.String(), "docs.example.com") {
+		t.Errorf("password form must not reveal the destination")
+	}
+	if info := getURLInfo(t, h, id, ""); info.OriginalUrl != "" || !info.PasswordProtected {
+		t.Errorf("metadata must not reveal the destination: %+v", info)
+	}
+
+	if w := postPassword(h, id, "wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("expected %v status code, got %v", http.StatusForbidden, w.Code)
+	}
+
+	w = postPassword(h, id, "hunter2")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "https://docs.example.com/internal" {
+		t.Errorf("unexpected response for correct password: %v %q", w.Code, w.Header().Get("Location"))
+	}
+	if h.clickCounts[id] != 1 {
+		t.Errorf("expected 1 click, got %v", h.clickCounts[id])
+	}
+}
+
+func TestPasswordProtectedLink_AttemptLimit(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+	h.PasswordMaxAttempts = 2
+	id := createLink(t, h, `{"url":"https://docs.example.com/internal","password":"hunter2"}`)
+
+	postPassword(h, id, "a")
+	postPassword(h, id, "b")
+
+	w := postPassword(h, id, "hunter2")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected %v status code, got %v", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected Retry-After header")
+	}
+}
@@ -0,0 +1,33 @@
+package handler
+
+import "strings"
+
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+)
+
+func IsPlatform(platform string) bool {
+	return platform == PlatformIOS || platform == PlatformAndroid
+}
+
+// detectPlatform guesses the platform from a User-Agent header. It returns an
+// empty string for anything that is not a known mobile platform
+func detectPlatform(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		return PlatformAndroid
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iPod"):
+		return PlatformIOS
+	}
+	return ""
+}
+
+// PlatformDestination returns the override for the platform of the User-Agent, if any
+func (l *Link) PlatformDestination(userAgent string) (string, bool) {
+	if len(l.PlatformURLs) == 0 {
+		return "", false
+	}
+	destination, ok := l.PlatformURLs[detectPlatform(userAgent)]
+	return destination, ok
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func postJSON(h *Handler, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.HandlePostRESTApi(w, r)
+	return w
+}
+
+func TestPlatformURLs(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+	id := createLink(t, h, `{"url":"https://example.com/app","platform_urls":{
+		"ios":"https://apps.apple.com/app/id123",
+		"android":"https://play.google.com/store/apps/details?id=com.example"}}`)
+
+	tests := []struct {
+		name      string
+		userAgent string
+		location  string
+	}{
+		{"iPhone", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15", "https://apps.apple.com/app/id123"},
+		{"Android", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36", "https://play.google.com/store/apps/details?id=com.example"},
+		{"Desktop", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36", "https://example.com/app"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/"+id, nil)
+			r.Header.Set("User-Agent", test.userAgent)
+			w := httptest.NewRecorder()
+			h.HandleGetById(w, r)
+
+			if location := w.Header().Get("Location"); location != test.location {
+				t.Errorf("incorrect Location. Got %q, wanted %q", location, test.location)
+			}
+			if w.Header().Get("Vary") != "User-Agent" {
+				t.Errorf("expected Vary: User-Agent, got %q", w.Header().Get("Vary"))
+			}
+		})
+	}
+
+	if info := getURLInfo(t, h, id, ""); info.PlatformURLs[PlatformIOS] != "https://apps.apple.com/app/id123" {
+		t.Errorf("metadata does not include platform URLs: %+v", info)
+	}
+}
+
+func TestPlatformURLs_Invalid(t *testing.T) {
+	h, _ := newScreenedHandler(t, "phish.example\n")
+
+	for _, body := range []string{
+		`{"url":"https://example.com","platform_urls":{"windows":"https://example.com/win"}}`,
+		`{"url":"https://example.com","platform_urls":{"ios":"javascript:alert(1)"}}`,
+	} {
+		if w := postJSON(h, body); w.Code != http.StatusBadRequest {
+			t.Errorf("expected %v status code for %s, got %v", http.StatusBadRequest, body, w.Code)
+		}
+	}
+	if w := postJSON(h, `{"url":"https://example.com","platform_urls":{"ios":"https://phish.example/app"}}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected %v status code, got %v", http.StatusForbidden, w.Code)
+	}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+	"github.com/advn1/url-shortener/internal/qr"
+)
+
+// parseQROptions reads size, level and margin from the query string
+func parseQROptions(r *http.Request) (qr.Options, error) {
+	opts := qr.DefaultOptions()
+	query := r.URL.Query()
+
+	if size := query.Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return opts, err
+		}
+		opts.Size = n
+	}
+	if level := query.Get("level"); level != "" {
+		parsed, err := qr.ParseLevel(level)
+		if err != nil {
+			return opts, err
+		}
+		opts.Level = parsed
+	}
+	if margin := query.Get("margin"); margin != "" {
+		n, err := strconv.Atoi(margin)
+		if err != nil {
+			return opts, err
+		}
+		opts.Margin = n
+	}
+	return opts, nil
+}
+
+// qrDataURI returns a PNG QR code of the short link as a base64 data URI
+func (h *Handler) qrDataURI(link *Link) (string, error) {
+	code, err := qr.Encode(h.linkURL(link), qr.DefaultOptions())
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := code.WritePNG(&buf); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// handler GET QR code of a short URL. format is png (default) or svg
+func (h *Handler) HandleQR(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleQR called", "path", r.URL.Path)
+
+	if r.Method != http.MethodGet {
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	domain, err := h.requestDomain(r)
+	if err != nil {
+		h.logger.Errorw("Domain lookup", "error", err, "host", r.Host)
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+		return
+	}
+	link, err := h.findLink(r.Context(), domain, id)
+	if err != nil {
+		h.writeLinkError(w, err, id)
+		return
+	}
+
+	opts, err := parseQROptions(r)
+	if err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid QR options", err.Error())
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = qr.FormatPNG
+	}
+	if format != qr.FormatPNG && format != qr.FormatSVG {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid QR options", "format must be png or svg")
+		return
+	}
+
+	code, err := qr.Encode(h.linkURL(link), opts)
+	if err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid QR options", err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if format == qr.FormatSVG {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = code.WriteSVG(&buf)
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+		err = code.WritePNG(&buf)
+	}
+	if err != nil {
+		h.logger.Errorw("QR render", "error", err, "id", id)
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+		return
+	}
+
+	// the code only depends on the short URL, which never changes
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func getQR(h *Handler, id string, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/"+id+"/qr?"+query, nil)
+	r.SetPathValue("id", id)
+	w := httptest.NewRecorder()
+	h.HandleQR(w, r)
+	return w
+}
+
+func TestQR_Formats(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+	id := createLink(t, h, `{"url":"https://example.com"}`)
+
+	if w := getQR(h, id, ""); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("unexpected PNG response: %v %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := getQR(h, id, "format=svg&size=512&level=H&margin=2"); w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "<?xml") {
+		t.Errorf("unexpected SVG response: %v %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := getQR(h, id, "size=huge"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, w.Code)
+	}
+	if w := getQR(h, "missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected %v status code, got %v", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestQR_InCreateResponse(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+
+	r := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"https://example.com","qr":true}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.HandlePostRESTApi(w, r)
+
+	var result PostURLResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("error on unmarshalling response body: %v", err)
+	}
+	if !strings.HasPrefix(result.QRCode, "data:image/png;base64,") {
+		t.Errorf("expected QR code data URI, got %q", result.QRCode)
+	}
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"net/url"
+	"strings"
+)
+
+// UTMParams are campaign parameters added to the destination of a link
+type UTMParams struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+}
+
+func (u UTMParams) values() url.Values {
+	values := url.Values{}
+	if u.Source != "" {
+		values.Set("utm_source", u.Source)
+	}
+	if u.Medium != "" {
+		values.Set("utm_medium", u.Medium)
+	}
+	if u.Campaign != "" {
+		values.Set("utm_campaign", u.Campaign)
+	}
+	return values
+}
+
+// withQuery adds the query of the visit (for links with PassQuery) and the UTM
+// defaults of the link to destination. Parameters already on the destination
+// are never replaced, and parameters of the visit win over the defaults. The
+// existing query is kept byte for byte, new parameters are appended
+func (l *Link) withQuery(destination string, rawQuery string) string {
+	extra := url.Values{}
+	if l.PassQuery && rawQuery != "" {
+		if incoming, err := url.ParseQuery(rawQuery); err == nil {
+			extra = incoming
+		}
+	}
+	for key, values := range l.UTM.values() {
+		if _, exists := extra[key]; !exists {
+			extra[key] = values
+		}
+	}
+	if len(extra) == 0 {
+		return destination
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+	existing, _ := url.ParseQuery(u.RawQuery)
+	for key := range existing {
+		delete(extra, key)
+	}
+	if len(extra) == 0 {
+		return destination
+	}
+
+	if u.RawQuery == "" {
+		u.RawQuery = extra.Encode()
+	} else {
+		u.RawQuery = strings.TrimSuffix(u.RawQuery, "&") + "&" + extra.Encode()
+	}
+	return u.String()
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLinkWithQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		link        Link
+		destination string
+		query       string
+		want        string
+	}{
+		{"query dropped by default", Link{}, "https://example.com/a", "x=1", "https://example.com/a"},
+		{"query passed through", Link{PassQuery: true}, "https://example.com/a", "x=1", "https://example.com/a?x=1"},
+		{"destination params kept", Link{PassQuery: true}, "https://example.com/a?x=0&b=2", "x=1&c=3", "https://example.com/a?x=0&b=2&c=3"},
+		{"utm defaults added", Link{UTM: UTMParams{Source: "print", Campaign: "spring"}}, "https://example.com/a", "", "https://example.com/a?utm_campaign=spring&utm_source=print"},
+		{"utm on destination wins", Link{UTM: UTMParams{Source: "print"}}, "https://example.com/a?utm_source=partner", "", "https://example.com/a?utm_source=partner"},
+		{"visit wins over defaults", Link{PassQuery: true, UTM: UTMParams{Source: "print", Medium: "qr"}}, "https://example.com/a", "utm_source=newsletter", "https://example.com/a?utm_medium=qr&utm_source=newsletter"},
+		{"fragment kept", Link{PassQuery: true}, "https://example.com/a#top", "x=1", "https://example.com/a?x=1#top"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.link.withQuery(test.destination, test.query); got != test.want {
+				t.Errorf("incorrect destination. Got %q, wanted %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPassQuery_Redirect(t *testing.T) {
+	h := newAdminHandler(t)
+	h.StoragePath = t.TempDir() + "/storage.json"
+	id := createLink(t, h, `{"url":"https://example.com/landing?ref=short","pass_query":true,"utm":{"source":"qr"}}`)
+
+	r := httptest.NewRequest("GET", "/"+id+"?utm_campaign=spring&ref=spoofed", nil)
+	w := httptest.NewRecorder()
+	h.HandleGetById(w, r)
+
+	if location := w.Header().Get("Location"); location != "https://example.com/landing?ref=short&utm_campaign=spring&utm_source=qr" {
+		t.Errorf("incorrect Location: %q", location)
+	}
+	if clicks := storedClicks(t, h); len(clicks) != 1 || clicks[0].Destination != "https://example.com/landing?ref=short" {
+		t.Errorf("click must record the link destination, got %+v", clicks)
+	}
+}
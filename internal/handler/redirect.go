@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+)
+
+// IsRedirectType reports whether code is a status a link may redirect with
+func IsRedirectType(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+func isPermanentRedirect(code int) bool {
+	return code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect
+}
+
+// temporaryRedirect returns the temporary status with the same method semantics as code
+func temporaryRedirect(code int) int {
+	switch code {
+	case http.StatusMovedPermanently:
+		return http.StatusFound
+	case http.StatusPermanentRedirect:
+		return http.StatusTemporaryRedirect
+	}
+	return code
+}
+
+// redirectStatus returns the status the link redirects with
+func (h *Handler) redirectStatus(link *Link) int {
+	if link.RedirectType != 0 {
+		return link.RedirectType
+	}
+	return h.DefaultRedirectType
+}
+
+// setRedirectCacheHeaders lets browsers and CDNs cache permanent redirects.
+// Temporary ones must reach the server every time
+func (h *Handler) setRedirectCacheHeaders(w http.ResponseWriter, status int) {
+	if isPermanentRedirect(status) && h.PermanentRedirectMaxAge > 0 {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(h.PermanentRedirectMaxAge.Seconds())))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (b PostURLBody) Validate() error {
+	if b.RedirectType != 0 && !IsRedirectType(b.RedirectType) {
+		return fmt.Errorf("redirect_type must be one of 301, 302, 307, 308")
+	}
+	if b.Alias != "" {
+		if err := validateAlias(b.Alias); err != nil {
+			return err
+		}
+	}
+	if b.NotBefore != nil && b.NotAfter != nil && !b.NotAfter.After(*b.NotBefore) {
+		return fmt.Errorf("not_after must be after not_before")
+	}
+	if b.NotAfter != nil && !b.NotAfter.After(time.Now()) {
+		return fmt.Errorf("not_after must be in the future")
+	}
+	for platform := range b.PlatformURLs {
+		if !IsPlatform(platform) {
+			return fmt.Errorf("platform_urls keys must be one of %s, %s", PlatformIOS, PlatformAndroid)
+		}
+	}
+	for country := range b.CountryURLs {
+		if !isCountryCode(country) {
+			return fmt.Errorf("country_urls keys must be two letter country codes, got %q", country)
+		}
+	}
+	if err := b.Destinations.validate(); err != nil {
+		return err
+	}
+	if b.Sticky && len(b.Destinations) == 0 {
+		return fmt.Errorf("sticky requires destinations")
+	}
+	if b.MaxClicks < 0 {
+		return fmt.Errorf("max_clicks must not be negative")
+	}
+	if len(b.Password) > maxLinkPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", maxLinkPasswordLength)
+	}
+	return nil
+}
+
+// serveInactiveLink answers for a link outside of its activation window as if
+// it did not exist, or sends the visitor to the coming soon page
+func (h *Handler) serveInactiveLink(w http.ResponseWriter, r *http.Request, link *Link) {
+	w.Header().Set("Cache-Control", "no-store")
+	if h.ComingSoonURL != "" {
+		http.Redirect(w, r, h.ComingSoonURL, http.StatusFound)
+		return
+	}
+	h.logger.Infow("Inactive link visited", "id", link.ShortUrl)
+	jsonutils.WriteJSONError(w, http.StatusNotFound, "Non existing ID", "provided short URL ID doesn't exists")
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// createLink shortens a URL through the REST API and returns the short ID
+func createLink(t *testing.T, h *Handler, body string) string {
+	t.Helper()
+
+	r := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.HandlePostRESTApi(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("incorrect status code on create. Got %v, wanted %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	var result PostURLResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("error on unmarshalling response body: %v", err)
+	}
+	return result.ShortUrl
+}
+
+func visit(h *Handler, id string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/"+id, nil)
+	w := httptest.NewRecorder()
+	h.HandleGetById(w, r)
+	return w
+}
+
+func TestRedirectType_PerLink(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+
+	permanent := createLink(t, h, `{"url":"https://example.com/docs","redirect_type":308}`)
+	w := visit(h, permanent)
+
+	if w.Code != http.StatusPermanentRedirect {
+		t.Errorf("incorrect status code. Got %v, wanted %v", w.Code, http.StatusPermanentRedirect)
+	}
+	if w.Header().Get("Cache-Control") != "public, max-age=86400" {
+		t.Errorf("incorrect Cache-Control for permanent redirect: %q", w.Header().Get("Cache-Control"))
+	}
+
+	temporary := createLink(t, h, `{"url":"https://example.com/campaign","redirect_type":302}`)
+	w = visit(h, temporary)
+
+	if w.Code != http.StatusFound {
+		t.Errorf("incorrect status code. Got %v, wanted %v", w.Code, http.StatusFound)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("incorrect Cache-Control for temporary redirect: %q", w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestRedirectType_ServerDefault(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+	h.DefaultRedirectType = http.StatusMovedPermanently
+
+	id := createLink(t, h, `{"url":"https://example.com"}`)
+	if w := visit(h, id); w.Code != http.StatusMovedPermanently {
+		t.Errorf("incorrect status code. Got %v, wanted %v", w.Code, http.StatusMovedPermanently)
+	}
+}
+
+func TestRedirectType_Invalid(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+
+	r := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"https://example.com","redirect_type":200}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.HandlePostRESTApi(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestActivationWindow(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+
+	launch := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	id := createLink(t, h, `{"url":"https://example.com/campaign","not_before":"`+launch+`"}`)
+
+	if w := visit(h, id); w.Code != http.StatusNotFound {
+		t.Errorf("expected %v status code before launch, got %v", http.StatusNotFound, w.Code)
+	}
+
+	h.ComingSoonURL = "https://example.com/coming-soon"
+	if w := visit(h, id); w.Code != http.StatusFound || w.Header().Get("Location") != h.ComingSoonURL {
+		t.Errorf("expected redirect to coming soon page, got %v %q", w.Code, w.Header().Get("Location"))
+	}
+
+	launched := time.Now().Add(-time.Minute)
+	h.links[id].NotBefore = &launched
+	if w := visit(h, id); w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("expected redirect after launch, got %v", w.Code)
+	}
+}
+
+func TestActivationWindow_Inverted(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+
+	start := time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339)
+	end := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	r := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"https://example.com","not_before":"`+start+`","not_after":"`+end+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.HandlePostRESTApi(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+	"github.com/advn1/url-shortener/internal/middleware"
+)
+
+// Revision is a destination a link used to have before it was edited
+type Revision struct {
+	ShortUrl    string    `json:"short_url"`
+	Domain      string    `json:"domain,omitempty"`
+	OriginalUrl string    `json:"original_url"`
+	ReplacedAt  time.Time `json:"replaced_at"`
+	ReplacedBy  string    `json:"replaced_by,omitempty"`
+}
+
+// PatchURLBody changes the fields that are set
+type PatchURLBody struct {
+	OriginalUrl string    `json:"original_url,omitempty"`
+	Tags        *[]string `json:"tags,omitempty"`
+	Folder      *string   `json:"folder,omitempty"`
+}
+
+type URLHistoryResponse struct {
+	ShortUrl    string     `json:"short_url"`
+	OriginalUrl string     `json:"original_url"`
+	Revisions   []Revision `json:"revisions"`
+}
+
+// updateLinkURL points the link to a new destination, keeping the old one as
+// a revision. With labels the tags and the folder of updated are stored along
+func (h *Handler) updateLinkURL(ctx context.Context, updated *Link, replacedBy string, labels bool) error {
+	now := time.Now().UTC()
+
+	if h.dbConnection != nil {
+		tx, err := h.dbConnection.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		var previousUrl string
+		err = tx.QueryRowContext(ctx, "SELECT original_url FROM urls WHERE domain = $1 AND short_url = $2 FOR UPDATE",
+			updated.Domain, updated.ShortUrl).Scan(&previousUrl)
+		if err == sql.ErrNoRows {
+			return errLinkNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO url_revisions (short_url, domain, original_url, replaced_at, replaced_by) VALUES ($1, $2, $3, $4, NULLIF($5, ''))",
+			updated.ShortUrl, updated.Domain, previousUrl, now, replacedBy)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE urls SET original_url = $3, flagged = $4, flag_reason = NULLIF($5, '') WHERE domain = $1 AND short_url = $2",
+			updated.Domain, updated.ShortUrl, updated.OriginalUrl, updated.Flagged, updated.FlagReason)
+		if err != nil {
+			return err
+		}
+		if labels {
+			if err := setLinkLabels(ctx, tx, updated); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := updated.key()
+	previousUrl, exists := h.URLs[key]
+	if !exists {
+		return errLinkNotFound
+	}
+	revision := Revision{ShortUrl: updated.ShortUrl, Domain: updated.Domain, OriginalUrl: previousUrl, ReplacedAt: now, ReplacedBy: replacedBy}
+
+	if h.StoragePath != "" {
+		if err := h.appendRecord(fileRecord{Kind: recordRevision, Revision: &revision}); err != nil {
+			return err
+		}
+		jsonLink, err := json.Marshal(updated)
+		if err != nil {
+			return err
+		}
+		if _, _, err := saveToFile(jsonLink, h.StoragePath); err != nil {
+			return err
+		}
+	}
+
+	h.revisions[key] = append(h.revisions[key], revision)
+	h.URLs[key] = updated.OriginalUrl
+	h.links[key] = updated
+	h.search.add(updated)
+	return nil
+}
+
+// listRevisions returns previous destinations of a link, newest first
+func (h *Handler) listRevisions(ctx context.Context, domain string, id string) ([]Revision, error) {
+	if h.dbConnection != nil {
+		rows, err := h.dbConnection.QueryContext(ctx, `SELECT short_url, domain, original_url, replaced_at, COALESCE(replaced_by, '') FROM url_revisions
+			WHERE domain = $1 AND short_url = $2 ORDER BY replaced_at DESC, id DESC`, domain, id)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		revisions := make([]Revision, 0)
+		for rows.Next() {
+			var revision Revision
+			if err := rows.Scan(&revision.ShortUrl, &revision.Domain, &revision.OriginalUrl, &revision.ReplacedAt, &revision.ReplacedBy); err != nil {
+				return nil, err
+			}
+			revisions = append(revisions, revision)
+		}
+		return revisions, rows.Err()
+	}
+
+	h.mu.RLock()
+	revisions := slices.Clone(h.revisions[linkKey(domain, id)])
+	h.mu.RUnlock()
+
+	slices.Reverse(revisions)
+	if revisions == nil {
+		revisions = make([]Revision, 0)
+	}
+	return revisions, nil
+}
+
+func (h *Handler) patchURL(w http.ResponseWriter, r *http.Request, domain string, id string) {
+	link, err := h.findLink(r.Context(), domain, id)
+	if err != nil {
+		h.writeLinkError(w, err, id)
+		return
+	}
+
+	principal, ok := h.authorizeOwner(w, r, link, ScopeCreate)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		if middleware.WriteBodyTooLarge(w, err) {
+			return
+		}
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Failed to read request body", "failed to read request body")
+		return
+	}
+
+	var patchURLBody PatchURLBody
+	if err := json.Unmarshal(body, &patchURLBody); err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid JSON format", "")
+		return
+	}
+
+	if patchURLBody.OriginalUrl == "" && patchURLBody.Tags == nil && patchURLBody.Folder == nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Empty URL", "")
+		return
+	}
+
+	updated := *link
+	if patchURLBody.Tags != nil {
+		if updated.Tags, err = normalizeTags(*patchURLBody.Tags); err != nil {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid tags", err.Error())
+			return
+		}
+	}
+	if patchURLBody.Folder != nil {
+		if updated.Folder, err = normalizeFolder(*patchURLBody.Folder); err != nil {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid folder", err.Error())
+			return
+		}
+	}
+
+	if patchURLBody.OriginalUrl != "" {
+		originalUrl, err := h.URLPolicy.Normalize(patchURLBody.OriginalUrl)
+		if err != nil {
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid URL format", err.Error())
+			return
+		}
+
+		updated.OriginalUrl = originalUrl
+		updated.Flagged = false
+		updated.FlagReason = ""
+		if result, ok := h.screenLink(&updated); !ok {
+			jsonutils.WriteJSONError(w, http.StatusForbidden, "Blocked URL", result.Reason)
+			return
+		}
+	}
+
+	// a new destination and new labels are stored at once, so a failed PATCH changes nothing
+	labels := patchURLBody.Tags != nil || patchURLBody.Folder != nil
+	if updated.OriginalUrl != link.OriginalUrl {
+		err = h.updateLinkURL(r.Context(), &updated, principal, labels)
+	} else if labels {
+		err = h.updateLinkLabels(r.Context(), &updated)
+	}
+	if err != nil {
+		h.writeLinkError(w, err, id)
+		return
+	}
+
+	json.NewEncoder(w).Encode(PostURLResponse{Uuid: updated.Uuid, ShortUrl: updated.ShortUrl, OriginalUrl: updated.OriginalUrl, RedirectType: h.redirectStatus(&updated),
+		Domain: updated.Domain})
+}
+
+// handler for the destination history (GET) of a short URL
+func (h *Handler) HandleURLHistory(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleURLHistory called", "path", r.URL.Path)
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	domain, ok := h.queryDomain(w, r)
+	if !ok {
+		return
+	}
+
+	link, err := h.findLink(r.Context(), domain, id)
+	if err != nil {
+		h.writeLinkError(w, err, id)
+		return
+	}
+
+	if _, ok := h.authorizeOwner(w, r, link, ScopeReadStats); !ok {
+		return
+	}
+
+	revisions, err := h.listRevisions(r.Context(), domain, id)
+	if err != nil {
+		h.logger.Errorw("List revisions", "error", err, "id", id)
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+		return
+	}
+
+	json.NewEncoder(w).Encode(URLHistoryResponse{ShortUrl: link.ShortUrl, OriginalUrl: link.OriginalUrl, Revisions: revisions})
+}
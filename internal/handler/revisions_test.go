@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func createOwnedLink(t *testing.T, h *Handler, key string, originalURL string) string {
+	t.Helper()
+
+	res := shortenWithKeyURL(h, key, originalURL)
+	defer res.Body.Close()
+
+	var result PostURLResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatalf("error on decoding response: %v", err)
+	}
+	return result.ShortUrl
+}
+
+func shortenWithKeyURL(h *Handler, key string, originalURL string) *http.Response {
+	r := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"`+originalURL+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	h.HandlePostRESTApi(w, r)
+	return w.Result()
+}
+
+func patchURL(h *Handler, id string, key string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("PATCH", "/api/urls/"+id, strings.NewReader(body))
+	r.SetPathValue("id", id)
+	r.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	h.HandleURLById(w, r)
+	return w
+}
+
+func getHistory(t *testing.T, h *Handler, id string, key string) URLHistoryResponse {
+	t.Helper()
+
+	r := httptest.NewRequest("GET", "/api/urls/"+id+"/history", nil)
+	r.SetPathValue("id", id)
+	r.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	h.HandleURLHistory(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code on history. Got %v, wanted %v", w.Code, http.StatusOK)
+	}
+
+	var history URLHistoryResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatalf("error on decoding history: %v", err)
+	}
+	return history
+}
+
+func TestPatchURL_OwnerUpdatesDestination(t *testing.T) {
+	storagePath := t.TempDir() + "/storage.json"
+	h := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	h.AdminToken = "admin-secret"
+
+	key := createAPIKey(t, h, `{"owner":"print","scopes":["create","read-stats"]}`)
+	id := createOwnedLink(t, h, key.Key, "https://example.com/typo")
+
+	w := patchURL(h, id, key.Key, `{"original_url":"https://example.com/fixed"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	if location := visit(h, id).Header().Get("Location"); location != "https://example.com/fixed" {
+		t.Errorf("link still redirects to %q", location)
+	}
+
+	history := getHistory(t, h, id, key.Key)
+	if history.OriginalUrl != "https://example.com/fixed" || len(history.Revisions) != 1 || history.Revisions[0].OriginalUrl != "https://example.com/typo" {
+		t.Errorf("unexpected history: %+v", history)
+	}
+
+	reloaded := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading storage file: %v", err)
+	}
+
+	history = getHistory(t, reloaded, id, key.Key)
+	if history.OriginalUrl != "https://example.com/fixed" || len(history.Revisions) != 1 {
+		t.Errorf("history was not restored from file: %+v", history)
+	}
+}
+
+func TestPatchURL_DestinationAndLabels(t *testing.T) {
+	h := newAdminHandler(t)
+	key := createAPIKey(t, h, `{"owner":"print","scopes":["create","read-stats"]}`)
+	id := createOwnedLink(t, h, key.Key, "https://example.com/typo")
+
+	if w := patchURL(h, id, key.Key, `{"original_url":"https://example.com/fixed","tags":["print"],"folder":"flyers"}`); w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	info := getURLInfo(t, h, id, key.Key)
+	if info.OriginalUrl != "https://example.com/fixed" || len(info.Tags) != 1 || info.Tags[0] != "print" || info.Folder != "flyers" {
+		t.Errorf("patch was not fully applied: %+v", info)
+	}
+
+	// an invalid part leaves the link as it was
+	if w := patchURL(h, id, key.Key, `{"original_url":"https://example.com/other","tags":["No Spaces"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, w.Code)
+	}
+	if info := getURLInfo(t, h, id, key.Key); info.OriginalUrl != "https://example.com/fixed" {
+		t.Errorf("failed patch changed the destination to %q", info.OriginalUrl)
+	}
+}
+
+func TestPatchURL_OnlyOwner(t *testing.T) {
+	h := newAdminHandler(t)
+
+	owner := createAPIKey(t, h, `{"owner":"print","scopes":["create"]}`)
+	other := createAPIKey(t, h, `{"owner":"growth","scopes":["create"]}`)
+	id := createOwnedLink(t, h, owner.Key, "https://example.com/typo")
+
+	if w := patchURL(h, id, other.Key, `{"original_url":"https://example.com/hijack"}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected %v status code, got %v", http.StatusForbidden, w.Code)
+	}
+	if w := patchURL(h, id, owner.Key, `{"original_url":"javascript:alert(1)"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, w.Code)
+	}
+	if w := patchURL(h, "missing", owner.Key, `{"original_url":"https://example.com"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected %v status code, got %v", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestPatchURL_RecordsWhoReplaced(t *testing.T) {
+	h := newAdminHandler(t)
+	key := createAPIKey(t, h, `{"owner":"print","scopes":["create","read-stats"]}`)
+	id := createOwnedLink(t, h, key.Key, "https://example.com/typo")
+
+	if w := patchURL(h, id, "admin-secret", `{"original_url":"https://example.com/fixed"}`); w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if w := patchURL(h, id, key.Key, `{"original_url":"https://example.com/final"}`); w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	history := getHistory(t, h, id, key.Key)
+	if len(history.Revisions) != 2 || history.Revisions[0].ReplacedBy != "print" || history.Revisions[1].ReplacedBy != "admin" {
+		t.Errorf("unexpected replaced_by in history: %+v", history.Revisions)
+	}
+}
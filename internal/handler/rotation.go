@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxDestinations      = 10
+	maxDestinationWeight = 1000
+
+	// how long a sticky visitor keeps seeing the same destination
+	stickyCookieMaxAge = 30 * 24 * time.Hour
+)
+
+// WeightedDestination is one of the destinations a link rotates between.
+// Each redirect picks it with a probability of Weight / sum of all weights
+type WeightedDestination struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// WeightedDestinations is stored as a JSON array
+type WeightedDestinations []WeightedDestination
+
+// Value implements driver.Valuer. An empty list is stored as NULL
+func (d WeightedDestinations) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (d *WeightedDestinations) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*d = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, d)
+	case string:
+		return json.Unmarshal([]byte(src), d)
+	}
+	return fmt.Errorf("cannot scan %T into WeightedDestinations", src)
+}
+
+func (d WeightedDestinations) validate() error {
+	if len(d) > maxDestinations {
+		return fmt.Errorf("at most %d destinations are allowed", maxDestinations)
+	}
+	for _, destination := range d {
+		if destination.URL == "" {
+			return fmt.Errorf("destinations must have a url")
+		}
+		if destination.Weight <= 0 || destination.Weight > maxDestinationWeight {
+			return fmt.Errorf("destination weights must be between 1 and %d", maxDestinationWeight)
+		}
+	}
+	return nil
+}
+
+// pick returns the index of a random destination, chosen by weight
+func (d WeightedDestinations) pick() int {
+	total := 0
+	for _, destination := range d {
+		total += destination.Weight
+	}
+
+	n := rand.IntN(total)
+	for i, destination := range d {
+		if n < destination.Weight {
+			return i
+		}
+		n -= destination.Weight
+	}
+	return len(d) - 1
+}
+
+// normalizeDestinations normalizes every destination URL with the URL policy
+func (h *Handler) normalizeDestinations(destinations WeightedDestinations) (WeightedDestinations, error) {
+	if len(destinations) == 0 {
+		return nil, nil
+	}
+
+	normalized := make(WeightedDestinations, len(destinations))
+	for i, destination := range destinations {
+		destinationURL, err := h.URLPolicy.Normalize(destination.URL)
+		if err != nil {
+			return nil, fmt.Errorf("destination %d: %w", i+1, err)
+		}
+		normalized[i] = WeightedDestination{URL: destinationURL, Weight: destination.Weight}
+	}
+	return normalized, nil
+}
+
+// stickyCookieName is derived from a hash of the domain and code, as
+// namespaced codes contain a "/", which cookie names can't
+func stickyCookieName(link *Link) string {
+	sum := sha256.Sum256([]byte(link.key()))
+	return "dest_" + hex.EncodeToString(sum[:8])
+}
+
+// chooseDestination returns where the visitor is sent. A platform override
+// wins, then a country override, then weighted rotation, then the original
+// URL. For a new visitor of a sticky link it also returns the cookie that
+// remembers the rotated destination, to be set once the redirect is served
+func (h *Handler) chooseDestination(r *http.Request, link *Link, country string) (string, *http.Cookie) {
+	if destination, ok := link.PlatformDestination(r.UserAgent()); ok {
+		return destination, nil
+	}
+	if destination, ok := link.CountryDestination(country); ok {
+		return destination, nil
+	}
+	if len(link.Destinations) == 0 {
+		return link.OriginalUrl, nil
+	}
+
+	if link.Sticky {
+		if cookie, err := r.Cookie(stickyCookieName(link)); err == nil {
+			if i, err := strconv.Atoi(cookie.Value); err == nil && i >= 0 && i < len(link.Destinations) {
+				return link.Destinations[i].URL, nil
+			}
+		}
+	}
+
+	i := link.Destinations.pick()
+	if !link.Sticky {
+		return link.Destinations[i].URL, nil
+	}
+	return link.Destinations[i].URL, &http.Cookie{
+		Name:     stickyCookieName(link),
+		Value:    strconv.Itoa(i),
+		Path:     "/" + link.ShortUrl,
+		MaxAge:   int(stickyCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
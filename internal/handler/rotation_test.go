@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRotation_SplitsByWeight(t *testing.T) {
+	h := newAdminHandler(t)
+	id := createLink(t, h, `{"url":"https://example.com","destinations":[
+		{"url":"https://example.com/a","weight":3},
+		{"url":"https://example.com/b","weight":1}]}`)
+
+	served := make(map[string]int)
+	for range 400 {
+		w := visit(h, id)
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("incorrect status code. Got %v, wanted %v", w.Code, http.StatusTemporaryRedirect)
+		}
+		served[w.Header().Get("Location")]++
+	}
+
+	if len(served) != 2 || served["https://example.com/a"] < served["https://example.com/b"] {
+		t.Errorf("unexpected split: %v", served)
+	}
+
+	info := getURLInfo(t, h, id, h.AdminToken)
+	if info.DestinationClicks["https://example.com/a"] != int64(served["https://example.com/a"]) ||
+		info.DestinationClicks["https://example.com/b"] != int64(served["https://example.com/b"]) {
+		t.Errorf("clicks not attributed to served destinations: %v, served %v", info.DestinationClicks, served)
+	}
+}
+
+func TestRotation_Sticky(t *testing.T) {
+	h := newAdminHandler(t)
+	id := createLink(t, h, `{"url":"https://example.com","sticky":true,"destinations":[
+		{"url":"https://example.com/a","weight":1},
+		{"url":"https://example.com/b","weight":1}]}`)
+
+	first := visit(h, id)
+	cookies := first.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a sticky cookie, got %v", cookies)
+	}
+
+	for range 20 {
+		r := httptest.NewRequest("GET", "/"+id, nil)
+		r.AddCookie(cookies[0])
+		w := httptest.NewRecorder()
+		h.HandleGetById(w, r)
+
+		if w.Header().Get("Location") != first.Header().Get("Location") {
+			t.Fatalf("sticky visitor was sent to %q after %q", w.Header().Get("Location"), first.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRotation_StickyCookieOnlyOnRedirect(t *testing.T) {
+	h := newAdminHandler(t)
+	launch := time.Now().Add(time.Hour)
+	link := &Link{Uuid: uuid.New(), ShortUrl: "team/promo", OriginalUrl: "https://example.com", Sticky: true,
+		Destinations: WeightedDestinations{{URL: "https://example.com/a", Weight: 1}, {URL: "https://example.com/b", Weight: 1}}}
+	if err := h.saveLink(context.Background(), link); err != nil {
+		t.Fatalf("error on saving link: %v", err)
+	}
+
+	for _, r := range []*http.Request{httptest.NewRequest("HEAD", "/team/promo", nil), httptest.NewRequest("GET", "/team/promo+", nil)} {
+		w := httptest.NewRecorder()
+		h.HandleGetById(w, r)
+		if cookies := w.Result().Cookies(); len(cookies) != 0 {
+			t.Errorf("%s %s must not assign a destination, got %v", r.Method, r.URL.Path, cookies)
+		}
+	}
+
+	// namespaced codes still get a valid cookie name
+	w := visit(h, "team/promo")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Path != "/team/promo" {
+		t.Fatalf("expected a sticky cookie, got %v", w.Header().Values("Set-Cookie"))
+	}
+
+	link.NotBefore = &launch
+	if w := visit(h, "team/promo"); w.Code != http.StatusNotFound || len(w.Result().Cookies()) != 0 {
+		t.Errorf("inactive link must not assign a destination, got %v %v", w.Code, w.Header().Values("Set-Cookie"))
+	}
+}
+
+func TestRotation_Invalid(t *testing.T) {
+	h := newAdminHandler(t)
+
+	for _, body := range []string{
+		`{"url":"https://example.com","destinations":[{"url":"https://example.com/a","weight":0}]}`,
+		`{"url":"https://example.com","destinations":[{"url":"ftp://example.com/a","weight":1}]}`,
+		`{"url":"https://example.com","sticky":true}`,
+	} {
+		if w := postJSON(h, body); w.Code != http.StatusBadRequest {
+			t.Errorf("expected %v status code for %s, got %v", http.StatusBadRequest, body, w.Code)
+		}
+	}
+}
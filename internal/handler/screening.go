@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+	"github.com/advn1/url-shortener/internal/screening"
+)
+
+// screenLink checks the destinations of a new link and marks it for review if
+// needed. It returns false when the link must be rejected
+func (h *Handler) screenLink(link *Link) (screening.Result, bool) {
+	// the most severe verdict of all destinations wins
+	result := h.Screener.Check(link.OriginalUrl)
+	destinations := make([]string, 0, len(link.PlatformURLs)+len(link.CountryURLs)+len(link.Destinations))
+	for _, destination := range link.PlatformURLs {
+		destinations = append(destinations, destination)
+	}
+	for _, destination := range link.CountryURLs {
+		destinations = append(destinations, destination)
+	}
+	for _, destination := range link.Destinations {
+		destinations = append(destinations, destination.URL)
+	}
+	for _, destination := range destinations {
+		if destinationResult := h.Screener.Check(destination); destinationResult.Verdict > result.Verdict {
+			result = destinationResult
+		}
+	}
+
+	switch result.Verdict {
+	case screening.Block:
+		h.logger.Warnw("Blocked URL rejected", "url", link.OriginalUrl, "reason", result.Reason)
+		return result, false
+	case screening.Flag:
+		h.logger.Warnw("URL flagged for review", "url", link.OriginalUrl, "reason", result.Reason)
+		link.Flagged = true
+		link.FlagReason = result.Reason
+	}
+	return result, true
+}
+
+// serveBlocked shows the warning page instead of redirecting when the
+// blocklist has come to include destination since the link was created. It
+// is only called once the visitor may see where the link goes
+func (h *Handler) serveBlocked(w http.ResponseWriter, link *Link, destination string) bool {
+	result := h.Screener.Check(destination)
+	if result.Verdict != screening.Block {
+		return false
+	}
+	h.logger.Warnw("Blocked link visited", "id", link.ShortUrl, "reason", result.Reason)
+	renderBlockedPage(w, link, destination, result.Reason)
+	return true
+}
+
+// flaggedLink is a link as listed for review. The empty PasswordHash shadows
+// the one of Link, like exportLink does
+type flaggedLink struct {
+	*Link
+	PasswordHash      string `json:"password_hash,omitempty"`
+	PasswordProtected bool   `json:"password_protected,omitempty"`
+}
+
+// handler for listing (GET) links flagged for review. Admin only
+func (h *Handler) HandleFlaggedLinks(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleFlaggedLinks called", "path", r.URL.Path)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !h.isAdmin(r) {
+		jsonutils.WriteJSONError(w, http.StatusUnauthorized, "Unauthorized", "admin token required")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+		return
+	}
+
+	links, err := h.listFlaggedLinks(r.Context())
+	if err != nil {
+		h.logger.Errorw("List flagged links", "error", err)
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+		return
+	}
+
+	response := make([]flaggedLink, 0, len(links))
+	for _, link := range links {
+		response = append(response, flaggedLink{Link: link, PasswordProtected: link.PasswordHash != ""})
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/screening"
+	"go.uber.org/zap"
+)
+
+func newScreenedHandler(t *testing.T, blocklist string) (*Handler, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte(blocklist), 0664); err != nil {
+		t.Fatalf("error on writing blocklist: %v", err)
+	}
+
+	screener, err := screening.New(path)
+	if err != nil {
+		t.Fatalf("error on loading blocklist: %v", err)
+	}
+
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+	h.Screener = screener
+	return h, path
+}
+
+func TestScreening_RejectsBlockedAndPrivate(t *testing.T) {
+	h, _ := newScreenedHandler(t, "phish.example\n")
+
+	for _, blockedURL := range []string{"https://login.phish.example/", "http://127.0.0.1:6060/debug", "http://2130706433/", "http://0x7f.1/", "http://127.1/"} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(blockedURL))
+		w := httptest.NewRecorder()
+		h.HandlePost(w, r)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected %v status code for %q, got %v", http.StatusForbidden, blockedURL, w.Code)
+		}
+
+		r = httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"`+blockedURL+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		h.HandlePostRESTApi(w, r)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected %v status code for %q, got %v", http.StatusForbidden, blockedURL, w.Code)
+		}
+	}
+
+	if len(h.URLs) != 0 {
+		t.Errorf("blocked URLs must not be saved, got %v", h.URLs)
+	}
+}
+
+func TestScreening_FlagsSuspicious(t *testing.T) {
+	h, _ := newScreenedHandler(t, "")
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader("https://bank.com@example.com/login"))
+	w := httptest.NewRecorder()
+	h.HandlePost(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("incorrect status code. Got %v, wanted %v", w.Code, http.StatusCreated)
+	}
+
+	links, err := h.listFlaggedLinks(t.Context())
+	if err != nil || len(links) != 1 {
+		t.Fatalf("expected one flagged link, got %v (%v)", len(links), err)
+	}
+	if links[0].FlagReason == "" {
+		t.Errorf("flagged link has no reason")
+	}
+}
+
+func TestScreening_FlaggedListHidesPasswordHash(t *testing.T) {
+	h, _ := newScreenedHandler(t, "")
+	h.AdminToken = "admin-secret"
+	createLink(t, h, `{"url":"https://bank.com@example.com/login","password":"hunter2"}`)
+
+	r := httptest.NewRequest("GET", "/api/admin/flagged", nil)
+	r.Header.Set("Authorization", "Bearer admin-secret")
+	w := httptest.NewRecorder()
+	h.HandleFlaggedLinks(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var links []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &links); err != nil || len(links) != 1 {
+		t.Fatalf("expected one flagged link, got %s (%v)", w.Body.String(), err)
+	}
+	if _, exists := links[0]["password_hash"]; exists {
+		t.Errorf("flagged list leaks the password hash: %s", w.Body.String())
+	}
+	if links[0]["password_protected"] != true || links[0]["flag_reason"] == nil {
+		t.Errorf("unexpected flagged link: %s", w.Body.String())
+	}
+}
+
+func TestScreening_WarningPageForBlockedLink(t *testing.T) {
+	h, path := newScreenedHandler(t, "")
+	h.URLs["e1ef4c662c790d8e4f72"] = "https://phish.example/login"
+
+	if err := os.WriteFile(path, []byte("phish.example\n"), 0664); err != nil {
+		t.Fatalf("error on writing blocklist: %v", err)
+	}
+	if err := h.Screener.Reload(); err != nil {
+		t.Fatalf("error on reloading blocklist: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/e1ef4c662c790d8e4f72", nil)
+	w := httptest.NewRecorder()
+	h.HandleGetById(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("incorrect status code. Got %v, wanted %v", w.Code, http.StatusForbidden)
+	}
+	if w.Header().Get("Location") != "" {
+		t.Errorf("blocked link must not redirect")
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("expected HTML warning page, got Content-Type %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestScreening_BlockedPageRevealsNothingEarly(t *testing.T) {
+	h, path := newScreenedHandler(t, "")
+	protected := createLink(t, h, `{"url":"https://phish.example/protected","password":"hunter2"}`)
+	launch := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	upcoming := createLink(t, h, `{"url":"https://phish.example/upcoming","not_before":"`+launch+`"}`)
+	override := createLink(t, h, `{"url":"https://example.com/app","platform_urls":{"ios":"https://phish.example/ios"}}`)
+
+	if err := os.WriteFile(path, []byte("phish.example\n"), 0664); err != nil {
+		t.Fatalf("error on writing blocklist: %v", err)
+	}
+	if err := h.Screener.Reload(); err != nil {
+		t.Fatalf("error on reloading blocklist: %v", err)
+	}
+
+	if w := visit(h, protected); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "phish.example") {
+		t.Errorf("expected password form without the destination, got %v %q", w.Code, w.Body.String())
+	}
+	if w := postPassword(h, protected, "hunter2"); w.Code != http.StatusForbidden || w.Header().Get("Location") != "" {
+		t.Errorf("expected warning page after the password, got %v %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := visit(h, upcoming); w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "phish.example") {
+		t.Errorf("expected not found without the destination, got %v %q", w.Code, w.Body.String())
+	}
+
+	r := httptest.NewRequest("GET", "/"+override, nil)
+	r.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15")
+	w := httptest.NewRecorder()
+	h.HandleGetById(w, r)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "https://phish.example/ios") || strings.Contains(w.Body.String(), "example.com/app") {
+		t.Errorf("expected warning page naming the blocked override, got %v %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,504 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+	"github.com/google/uuid"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// searchIndex maps trigrams of short codes and destinations to link keys, so
+// substring search over the in-memory backends doesn't test every link. It is
+// guarded by Handler.mu
+type searchIndex struct {
+	trigrams map[string]map[string]struct{}
+	texts    map[string]string // indexed text of every link key
+}
+
+func searchText(link *Link) string {
+	return strings.ToLower(link.ShortUrl + "\n" + link.OriginalUrl)
+}
+
+func trigrams(text string) []string {
+	runes := []rune(text)
+	grams := make([]string, 0, max(len(runes)-2, 0))
+	for i := 0; i+3 <= len(runes); i++ {
+		grams = append(grams, string(runes[i:i+3]))
+	}
+	return grams
+}
+
+// add indexes a link, replacing what was indexed for it before
+func (i *searchIndex) add(link *Link) {
+	if i.trigrams == nil {
+		i.trigrams = make(map[string]map[string]struct{})
+		i.texts = make(map[string]string)
+	}
+
+	key := link.key()
+	text := searchText(link)
+	if previous, exists := i.texts[key]; exists {
+		if previous == text {
+			return
+		}
+		for _, gram := range trigrams(previous) {
+			delete(i.trigrams[gram], key)
+		}
+	}
+
+	i.texts[key] = text
+	for _, gram := range trigrams(text) {
+		if i.trigrams[gram] == nil {
+			i.trigrams[gram] = make(map[string]struct{})
+		}
+		i.trigrams[gram][key] = struct{}{}
+	}
+}
+
+// remove drops a link from the index
+func (i *searchIndex) remove(key string) {
+	for _, gram := range trigrams(i.texts[key]) {
+		delete(i.trigrams[gram], key)
+	}
+	delete(i.texts, key)
+}
+
+// match reports whether the link of key contains query, which must be lowercase
+func (i *searchIndex) match(key string, query string) bool {
+	return strings.Contains(i.texts[key], query)
+}
+
+// candidates returns the keys of links that may contain query. ok is false
+// for queries shorter than a trigram, which have to be tested against every link
+func (i *searchIndex) candidates(query string) (keys map[string]struct{}, ok bool) {
+	grams := trigrams(query)
+	if len(grams) == 0 {
+		return nil, false
+	}
+
+	// intersect starting from the rarest trigram
+	slices.SortFunc(grams, func(a, b string) int { return cmp.Compare(len(i.trigrams[a]), len(i.trigrams[b])) })
+	keys = make(map[string]struct{})
+	for key := range i.trigrams[grams[0]] {
+		keys[key] = struct{}{}
+	}
+	for _, gram := range grams[1:] {
+		for key := range keys {
+			if _, exists := i.trigrams[gram][key]; !exists {
+				delete(keys, key)
+			}
+		}
+	}
+	return keys, true
+}
+
+// orders of listings
+const (
+	sortCreatedAt = "created_at"
+	sortClicks    = "clicks"
+)
+
+// linkSort orders a listing by Field, with ties broken by creation time and
+// then by ID. The query parameter form is the field with a leading "-" for
+// descending order
+type linkSort struct {
+	Field      string
+	Descending bool
+}
+
+func parseLinkSort(value string) (linkSort, error) {
+	if value == "" {
+		return linkSort{Field: sortCreatedAt, Descending: true}, nil
+	}
+	field, descending := strings.CutPrefix(value, "-")
+	if field != sortCreatedAt && field != sortClicks {
+		return linkSort{}, errors.New("sort must be one of created_at, -created_at, clicks, -clicks")
+	}
+	return linkSort{Field: field, Descending: descending}, nil
+}
+
+func (s linkSort) String() string {
+	if s.Descending {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// compare orders a and b ascending by the sort key
+func (s linkSort) compare(a, b *Link) int {
+	if s.Field == sortClicks {
+		if c := cmp.Compare(a.Clicks, b.Clicks); c != 0 {
+			return c
+		}
+	}
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Uuid.String(), b.Uuid.String())
+}
+
+// linkCursor is a position in a listing, the sort key of the link the page
+// starts after. Backward cursors point to the page before the position
+type linkCursor struct {
+	Sort      string    `json:"s"`
+	Backward  bool      `json:"b,omitempty"`
+	Clicks    int64     `json:"c,omitempty"`
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"i"`
+}
+
+func newLinkCursor(sort linkSort, link *Link, backward bool) linkCursor {
+	cursor := linkCursor{Sort: sort.String(), Backward: backward, CreatedAt: link.CreatedAt.UTC(), ID: link.Uuid}
+	if sort.Field == sortClicks {
+		cursor.Clicks = link.Clicks
+	}
+	return cursor
+}
+
+// position is the cursor as a link, for comparing with linkSort.compare
+func (c *linkCursor) position() *Link {
+	return &Link{Clicks: c.Clicks, CreatedAt: c.CreatedAt, Uuid: c.ID}
+}
+
+func (h *Handler) signCursor(payload string) []byte {
+	mac := hmac.New(sha256.New, h.CursorSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// encodeCursor makes an opaque cursor that clients can't forge
+func (h *Handler) encodeCursor(cursor linkCursor) string {
+	data, _ := json.Marshal(cursor)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(h.signCursor(payload))
+}
+
+func (h *Handler) decodeCursor(value string) (*linkCursor, error) {
+	payload, signature, found := strings.Cut(value, ".")
+	if !found {
+		return nil, errInvalidCursor
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, h.signCursor(payload)) {
+		return nil, errInvalidCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var cursor linkCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// LinkFilter selects links of a listing. Zero fields match every link
+type LinkFilter struct {
+	Owner         string
+	Tags          []string // links must have all of them
+	Folder        string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Query         string // substring of the short code or the original URL
+	Sort          linkSort
+	Cursor        *linkCursor
+	Limit         int
+}
+
+// descending reports whether links are fetched in descending order. Backward
+// pages are fetched in the reverse order of the listing, nearest to the cursor first
+func (f LinkFilter) descending() bool {
+	return f.Sort.Descending != (f.Cursor != nil && f.Cursor.Backward)
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// listLinks returns up to filter.Limit links matching filter, following the
+// cursor in the order of filter.descending
+func (h *Handler) listLinks(ctx context.Context, filter LinkFilter) ([]*Link, error) {
+	if h.dbConnection != nil {
+		var conditions []string
+		var args []any
+		where := func(condition string, values ...any) {
+			for _, value := range values {
+				args = append(args, value)
+				condition = strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1)
+			}
+			conditions = append(conditions, condition)
+		}
+
+		if filter.Owner != "" {
+			where("owner = ?", filter.Owner)
+		}
+		if len(filter.Tags) > 0 {
+			where("tags @> ?::jsonb", Tags(filter.Tags))
+		}
+		if filter.Folder != "" {
+			where("folder = ?", filter.Folder)
+		}
+		if filter.CreatedAfter != nil {
+			where("created_at >= ?", *filter.CreatedAfter)
+		}
+		if filter.CreatedBefore != nil {
+			where("created_at < ?", *filter.CreatedBefore)
+		}
+		// pg_trgm indexes, when the extension could be created, serve ILIKE with leading wildcards
+		if filter.Query != "" {
+			pattern := "%" + escapeLike(filter.Query) + "%"
+			where(`(short_url ILIKE ? OR original_url ILIKE ?)`, pattern, pattern)
+		}
+
+		columns := []string{"created_at", "id"}
+		if filter.Sort.Field == sortClicks {
+			columns = slices.Insert(columns, 0, "clicks")
+		}
+		direction, operator := " ASC", ">"
+		if filter.descending() {
+			direction, operator = " DESC", "<"
+		}
+		if cursor := filter.Cursor; cursor != nil {
+			values := []any{cursor.CreatedAt, cursor.ID.String()}
+			if filter.Sort.Field == sortClicks {
+				values = slices.Insert(values, 0, any(cursor.Clicks))
+			}
+			placeholders := strings.Repeat("?, ", len(values)-1) + "?"
+			where("("+strings.Join(columns, ", ")+") "+operator+" ("+placeholders+")", values...)
+		}
+
+		query := "SELECT " + linkColumns + " FROM urls"
+		if len(conditions) > 0 {
+			query += " WHERE " + strings.Join(conditions, " AND ")
+		}
+		args = append(args, filter.Limit)
+		query += " ORDER BY " + strings.Join(columns, direction+", ") + direction + " LIMIT $" + strconv.Itoa(len(args))
+
+		rows, err := h.dbConnection.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		links := make([]*Link, 0)
+		for rows.Next() {
+			link, err := scanLink(rows)
+			if err != nil {
+				return nil, err
+			}
+			links = append(links, link)
+		}
+		return links, rows.Err()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	query := strings.ToLower(filter.Query)
+	candidates, indexed := h.search.candidates(query)
+	matches := func(key string, link *Link) bool {
+		if filter.Owner != "" && link.Owner != filter.Owner {
+			return false
+		}
+		for _, tag := range filter.Tags {
+			if !slices.Contains(link.Tags, tag) {
+				return false
+			}
+		}
+		if filter.Folder != "" && link.Folder != filter.Folder {
+			return false
+		}
+		if filter.CreatedAfter != nil && link.CreatedAt.Before(*filter.CreatedAfter) {
+			return false
+		}
+		if filter.CreatedBefore != nil && !link.CreatedAt.Before(*filter.CreatedBefore) {
+			return false
+		}
+		return query == "" || h.search.match(key, query)
+	}
+
+	links := make([]*Link, 0)
+	collect := func(key string) {
+		if link := h.links[key]; link != nil && matches(key, link) {
+			found := *link
+			found.OriginalUrl = h.URLs[key]
+			found.Clicks = h.clickCounts[key]
+			links = append(links, &found)
+		}
+	}
+	if indexed {
+		for key := range candidates {
+			collect(key)
+		}
+	} else {
+		for key := range h.links {
+			collect(key)
+		}
+	}
+
+	compare := filter.Sort.compare
+	if filter.descending() {
+		compare = func(a, b *Link) int { return filter.Sort.compare(b, a) }
+	}
+	if filter.Cursor != nil {
+		position := filter.Cursor.position()
+		links = slices.DeleteFunc(links, func(link *Link) bool { return compare(link, position) <= 0 })
+	}
+	slices.SortFunc(links, compare)
+	if len(links) > filter.Limit {
+		links = links[:filter.Limit]
+	}
+	return links, nil
+}
+
+// URLListResponse is a page of links. The cursors are empty on the first and
+// the last page, and are also sent in the Link header
+type URLListResponse struct {
+	URLs       []URLInfoResponse `json:"urls"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	PrevCursor string            `json:"prev_cursor,omitempty"`
+}
+
+// parseLinkFilter reads the filter of a listing from the query string
+func (h *Handler) parseLinkFilter(r *http.Request) (LinkFilter, error) {
+	query := r.URL.Query()
+	filter := LinkFilter{Query: strings.TrimSpace(query.Get("q")), Limit: h.DefaultPageSize}
+
+	var err error
+	if filter.Tags, err = normalizeTags(query["tag"]); err != nil {
+		return filter, err
+	}
+	if filter.Folder, err = normalizeFolder(query.Get("folder")); err != nil {
+		return filter, err
+	}
+	for name, bound := range map[string]**time.Time{"created_after": &filter.CreatedAfter, "created_before": &filter.CreatedBefore} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, errors.New(name + " must be an RFC 3339 time")
+			}
+			*bound = &parsed
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > h.MaxPageSize {
+			return filter, errors.New("limit must be between 1 and " + strconv.Itoa(h.MaxPageSize))
+		}
+	}
+	if filter.Sort, err = parseLinkSort(query.Get("sort")); err != nil {
+		return filter, err
+	}
+	if value := query.Get("cursor"); value != "" {
+		if filter.Cursor, err = h.decodeCursor(value); err != nil {
+			return filter, err
+		}
+		if filter.Cursor.Sort != filter.Sort.String() {
+			return filter, errors.New("cursor belongs to a listing with another sort")
+		}
+	}
+	return filter, nil
+}
+
+// pageURL is the URL of the listing of r at cursor
+func (h *Handler) pageURL(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	return h.BaseURL + r.URL.Path + "?" + query.Encode()
+}
+
+// handler for listing (GET) the links of the owner of the API key, filtered by
+// tag, folder, creation time and a search query. The admin lists every link,
+// or those of the owner query parameter
+func (h *Handler) HandleUserURLs(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleUserURLs called", "path", r.URL.Path)
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+		return
+	}
+
+	owner := r.URL.Query().Get("owner")
+	if !h.isAdmin(r) {
+		key, ok := h.requireScope(w, r, ScopeReadStats)
+		if !ok {
+			return
+		}
+		owner = key.Owner
+	}
+
+	filter, err := h.parseLinkFilter(r)
+	if err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+	filter.Owner = owner
+
+	// one more link than asked for tells whether there is a page after this one
+	pageSize := filter.Limit
+	filter.Limit++
+	links, err := h.listLinks(r.Context(), filter)
+	if err != nil {
+		h.logger.Errorw("List links", "error", err)
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+		return
+	}
+
+	more := len(links) > pageSize
+	if more {
+		links = links[:pageSize]
+	}
+	backward := filter.Cursor != nil && filter.Cursor.Backward
+	if backward {
+		slices.Reverse(links)
+	}
+
+	response := URLListResponse{URLs: make([]URLInfoResponse, 0, len(links))}
+	if len(links) > 0 {
+		if more || backward {
+			response.NextCursor = h.encodeCursor(newLinkCursor(filter.Sort, links[len(links)-1], false))
+		}
+		if (more && backward) || (!backward && filter.Cursor != nil) {
+			response.PrevCursor = h.encodeCursor(newLinkCursor(filter.Sort, links[0], true))
+		}
+	}
+
+	var pages []string
+	if response.NextCursor != "" {
+		pages = append(pages, "<"+h.pageURL(r, response.NextCursor)+`>; rel="next"`)
+	}
+	if response.PrevCursor != "" {
+		pages = append(pages, "<"+h.pageURL(r, response.PrevCursor)+`>; rel="prev"`)
+	}
+	if len(pages) > 0 {
+		w.Header().Set("Link", strings.Join(pages, ", "))
+	}
+
+	for _, link := range links {
+		info, err := h.linkInfo(r.Context(), link, true)
+		if err != nil {
+			h.logger.Errorw("Count clicks", "error", err, "id", link.ShortUrl)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+			return
+		}
+		response.URLs = append(response.URLs, info)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
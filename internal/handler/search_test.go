@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func listUserURLs(t *testing.T, h *Handler, key string, query url.Values) URLListResponse {
+	t.Helper()
+
+	r := httptest.NewRequest("GET", "/api/user/urls?"+query.Encode(), nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	h.HandleUserURLs(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code on listing. Got %v, wanted %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var list URLListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("error on decoding listing: %v", err)
+	}
+	return list
+}
+
+func createLabeledLink(t *testing.T, h *Handler, key string, body string) string {
+	t.Helper()
+
+	r := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	h.HandlePostRESTApi(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var result PostURLResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("error on decoding response: %v", err)
+	}
+	return result.ShortUrl
+}
+
+func shortCodes(list URLListResponse) []string {
+	codes := make([]string, 0, len(list.URLs))
+	for _, info := range list.URLs {
+		codes = append(codes, info.ShortUrl)
+	}
+	return codes
+}
+
+func TestUserURLs_Filters(t *testing.T) {
+	storagePath := t.TempDir() + "/storage.json"
+	h := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	h.AdminToken = "admin-secret"
+
+	key := createAPIKey(t, h, `{"owner":"marketing","scopes":["create","read-stats"]}`)
+	other := createAPIKey(t, h, `{"owner":"growth","scopes":["create","read-stats"]}`)
+
+	createLabeledLink(t, h, key.Key, `{"url":"https://example.com/spring-sale","alias":"spring","tags":["Sale","2024"],"folder":"/campaigns/2024/"}`)
+	createLabeledLink(t, h, key.Key, `{"url":"https://example.com/autumn-sale","alias":"autumn","tags":["sale"]}`)
+	createLabeledLink(t, h, key.Key, `{"url":"https://docs.example.com/onboarding","alias":"onboarding","folder":"docs"}`)
+	createLabeledLink(t, h, other.Key, `{"url":"https://example.com/spring-sale","alias":"theirs","tags":["sale"]}`)
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  string
+	}{
+		{name: "all own links", query: url.Values{}, want: "onboarding autumn spring"},
+		{name: "tag", query: url.Values{"tag": {"sale"}}, want: "autumn spring"},
+		{name: "every tag", query: url.Values{"tag": {"sale", "2024"}}, want: "spring"},
+		{name: "folder", query: url.Values{"folder": {"campaigns/2024"}}, want: "spring"},
+		{name: "search destination", query: url.Values{"q": {"SALE"}}, want: "autumn spring"},
+		{name: "search alias", query: url.Values{"q": {"board"}}, want: "onboarding"},
+		{name: "short search", query: url.Values{"q": {"do"}}, want: "onboarding"},
+		{name: "no match", query: url.Values{"q": {"winter"}}, want: ""},
+		{name: "created range", query: url.Values{"created_after": {time.Now().Add(time.Hour).Format(time.RFC3339)}}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(shortCodes(listUserURLs(t, h, key.Key, tt.query)), " "); got != tt.want {
+				t.Errorf("got links %q, wanted %q", got, tt.want)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("PATCH", "/api/urls/onboarding", strings.NewReader(`{"tags":["sale"],"folder":""}`))
+	r.SetPathValue("id", "onboarding")
+	r.Header.Set("Authorization", "Bearer "+key.Key)
+	w := httptest.NewRecorder()
+	h.HandleURLById(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code on patch. Got %v, wanted %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	reloaded := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading storage file: %v", err)
+	}
+	if got := strings.Join(shortCodes(listUserURLs(t, reloaded, key.Key, url.Values{"tag": {"sale"}})), " "); got != "onboarding autumn spring" {
+		t.Errorf("got links %q after reload", got)
+	}
+	if got := shortCodes(listUserURLs(t, reloaded, key.Key, url.Values{"folder": {"docs"}})); len(got) != 0 {
+		t.Errorf("folder was not cleared: %v", got)
+	}
+}
+
+func TestUserURLs_CursorPagination(t *testing.T) {
+	h := newAdminHandler(t)
+	key := createAPIKey(t, h, `{"owner":"marketing","scopes":["create","read-stats"]}`)
+
+	var created []string
+	for range 5 {
+		created = append(created, createOwnedLink(t, h, key.Key, "https://example.com"))
+	}
+	slices.Reverse(created)
+
+	var pages [][]string
+	var list URLListResponse
+	query := url.Values{"limit": {"2"}}
+	for {
+		if len(pages) > 3 {
+			t.Fatalf("pagination does not end")
+		}
+		list = listUserURLs(t, h, key.Key, query)
+		pages = append(pages, shortCodes(list))
+		if list.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", list.NextCursor)
+	}
+
+	var listed []string
+	for _, page := range pages {
+		listed = append(listed, page...)
+	}
+	if !slices.Equal(listed, created) {
+		t.Errorf("listed %v, wanted newest first %v", listed, created)
+	}
+
+	// walk back from the last page
+	query.Set("cursor", list.PrevCursor)
+	if back := listUserURLs(t, h, key.Key, query); !slices.Equal(shortCodes(back), pages[1]) || back.NextCursor == "" || back.PrevCursor == "" {
+		t.Errorf("previous page is %v, wanted %v", shortCodes(back), pages[1])
+	}
+}
+
+func TestUserURLs_SortByClicks(t *testing.T) {
+	h := newAdminHandler(t)
+	key := createAPIKey(t, h, `{"owner":"marketing","scopes":["create","read-stats"]}`)
+
+	quiet := createOwnedLink(t, h, key.Key, "https://example.com/quiet")
+	popular := createOwnedLink(t, h, key.Key, "https://example.com/popular")
+	some := createOwnedLink(t, h, key.Key, "https://example.com/some")
+	for range 3 {
+		visit(h, popular)
+	}
+	visit(h, some)
+
+	first := listUserURLs(t, h, key.Key, url.Values{"sort": {"-clicks"}, "limit": {"2"}})
+	if got := shortCodes(first); !slices.Equal(got, []string{popular, some}) {
+		t.Errorf("got %v, wanted most clicked first", got)
+	}
+
+	r := httptest.NewRequest("GET", "/api/user/urls?sort=-clicks&limit=2", nil)
+	r.Header.Set("Authorization", "Bearer "+key.Key)
+	w := httptest.NewRecorder()
+	h.HandleUserURLs(w, r)
+	if link := w.Header().Get("Link"); !strings.HasPrefix(link, "<http://localhost:8080/api/user/urls?") || !strings.HasSuffix(link, `>; rel="next"`) {
+		t.Errorf("unexpected Link header %q", link)
+	}
+
+	second := listUserURLs(t, h, key.Key, url.Values{"sort": {"-clicks"}, "limit": {"2"}, "cursor": {first.NextCursor}})
+	if got := shortCodes(second); !slices.Equal(got, []string{quiet}) {
+		t.Errorf("got %v on the second page, wanted %v", got, quiet)
+	}
+	if asc := shortCodes(listUserURLs(t, h, key.Key, url.Values{"sort": {"clicks"}})); !slices.Equal(asc, []string{quiet, some, popular}) {
+		t.Errorf("got %v, wanted least clicked first", asc)
+	}
+
+	// a cursor only works with its own sort, and can't be altered
+	payload, signature, _ := strings.Cut(first.NextCursor, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"s":"-clicks","c":1000,"t":"2020-01-01T00:00:00Z","i":"00000000-0000-0000-0000-000000000000"}`)) + "." + signature
+	for _, query := range []string{"limit=0", "limit=1000", "cursor=garbage", "cursor=" + forged, "cursor=" + payload + "." + signature + "&sort=created_at",
+		"sort=owner", "tag=No%20Spaces", "created_before=yesterday"} {
+		r := httptest.NewRequest("GET", "/api/user/urls?"+query, nil)
+		r.Header.Set("Authorization", "Bearer "+key.Key)
+		w := httptest.NewRecorder()
+		h.HandleUserURLs(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %v status code, got %v", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
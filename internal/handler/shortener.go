@@ -1,19 +1,24 @@
 package handler
 
 import (
-	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
+	"net/netip"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/advn1/url-shortener/internal/jsonutils"
+	"github.com/advn1/url-shortener/internal/middleware"
+	"github.com/advn1/url-shortener/internal/screening"
+	"github.com/advn1/url-shortener/internal/validator"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
@@ -22,18 +27,81 @@ type Handler struct {
 	BaseURL      string
 	URLs         map[string]string
 	StoragePath  string
+	AdminToken   string
+	URLPolicy    *validator.Policy
+	Screener     *screening.Screener
 	dbConnection *sql.DB
+	snapshotTx   *sql.Tx // read transaction of a snapshot, see Snapshot
 	logger       *zap.SugaredLogger
+
+	// links created without a redirect type use DefaultRedirectType.
+	// permanent redirects may be cached for PermanentRedirectMaxAge
+	DefaultRedirectType     int
+	PermanentRedirectMaxAge time.Duration
+
+	// clients are located with GeoIP, which may be nil. their address is
+	// taken from forwarding headers only when sent by one of TrustedProxies
+	GeoIP          CountryLocator
+	TrustedProxies []netip.Prefix
+
+	// links outside of their activation window redirect here. 404 when empty
+	ComingSoonURL string
+
+	// listings return DefaultPageSize links unless asked for up to MaxPageSize.
+	// their cursors are signed with CursorSecret
+	DefaultPageSize int
+	MaxPageSize     int
+	CursorSecret    []byte
+
+	// failed password attempts allowed per link and client within PasswordAttemptWindow
+	PasswordMaxAttempts   int
+	PasswordAttemptWindow time.Duration
+	passwordAttempts      attemptLimiter
+
+	// how long registered domains of the database are cached before
+	// ones registered by other instances are looked up again
+	DomainCacheTTL time.Duration
+	domainCache    domainCache
+
+	// in-memory state for the file and in-memory storage modes
+	mu         sync.RWMutex
+	links      map[string]*Link
+	apiKeys    map[string]*APIKey
+	revisions  map[string][]Revision
+	domains    map[string]*Domain
+	namespaces map[string]*Namespace
+	search     searchIndex
+
+	// only counters of clicks are kept in memory. the clicks themselves are
+	// read back from the storage file, and aren't kept without one
+	clickCounts       map[string]int64
+	destinationClicks map[string]map[string]int64
 }
 
 func New(baseURL string, urls map[string]string, storagePath string, db *sql.DB, sugar *zap.SugaredLogger) *Handler {
 	baseURL = strings.TrimSuffix(baseURL, "/")
 	return &Handler{
-		BaseURL:      baseURL,
-		URLs:         urls,
-		StoragePath:  storagePath,
-		dbConnection: db,
-		logger:       sugar,
+		BaseURL:                 baseURL,
+		URLs:                    urls,
+		StoragePath:             storagePath,
+		URLPolicy:               validator.DefaultPolicy(),
+		DefaultRedirectType:     http.StatusTemporaryRedirect,
+		PermanentRedirectMaxAge: 24 * time.Hour,
+		DefaultPageSize:         50,
+		MaxPageSize:             100,
+		CursorSecret:            randomSecret(),
+		PasswordMaxAttempts:     5,
+		PasswordAttemptWindow:   15 * time.Minute,
+		DomainCacheTTL:          time.Minute,
+		dbConnection:            db,
+		logger:                  sugar,
+		links:                   make(map[string]*Link),
+		apiKeys:                 make(map[string]*APIKey),
+		revisions:               make(map[string][]Revision),
+		domains:                 make(map[string]*Domain),
+		namespaces:              make(map[string]*Namespace),
+		clickCounts:             make(map[string]int64),
+		destinationClicks:       make(map[string]map[string]int64),
 	}
 }
 
@@ -46,6 +114,13 @@ func GenerateRandomUrl() string {
 	return encodedUrl
 }
 
+// randomSecret is a key for signatures that only need to hold until a restart
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
 // handler POST URL
 func (h *Handler) HandlePost(w http.ResponseWriter, r *http.Request) {
 	h.logger.Infow("HandlePost called", "path", r.URL.Path)
@@ -59,6 +134,9 @@ func (h *Handler) HandlePost(w http.ResponseWriter, r *http.Request) {
 
 		url, err := io.ReadAll(r.Body)
 		if err != nil {
+			if middleware.WriteBodyTooLarge(w, err) {
+				return
+			}
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -68,17 +146,32 @@ func (h *Handler) HandlePost(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		stringUrl := string(url)
-
-		if !strings.HasPrefix(stringUrl, "http://") && !strings.HasPrefix(stringUrl, "https://") {
+		stringUrl, err := h.URLPolicy.Normalize(string(url))
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		encodedUrl := GenerateRandomUrl()
-		h.URLs[encodedUrl] = string(url)
+		// links are created on the domain the request was sent to
+		domain, err := h.requestDomain(r)
+		if err != nil {
+			h.logger.Errorw("Domain lookup", "error", err, "host", r.Host)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		link := &Link{Uuid: uuid.New(), ShortUrl: GenerateRandomUrl(), OriginalUrl: stringUrl, Domain: domain}
+		if _, ok := h.screenLink(link); !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if err := h.saveLink(r.Context(), link); err != nil {
+			h.logger.Errorw("Save link", "error", err, "values", link)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 
-		fullUrl := h.BaseURL + "/" + encodedUrl
+		fullUrl := h.linkURL(link)
 
 		w.WriteHeader(http.StatusCreated)
 		w.Write([]byte(fullUrl))
@@ -87,58 +180,134 @@ func (h *Handler) HandlePost(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handler GET URL by ID
+// handler GET URL by ID. A trailing "+" shows a preview page instead of
+// redirecting, and HEAD answers with the redirect without counting a click.
+// Password protected links show a form instead, which is POSTed back here
 func (h *Handler) HandleGetById(w http.ResponseWriter, r *http.Request) {
 	h.logger.Infow("HandleGetById called", "path", r.URL.Path)
 
-	if r.Method == http.MethodGet {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodPost {
 		stringId := strings.TrimPrefix(r.URL.Path, "/")
 		stringId = strings.TrimSpace(stringId)
+		stringId, preview := strings.CutSuffix(stringId, "+")
 
 		if stringId == "" {
 			jsonutils.WriteJSONError(w, http.StatusBadRequest, "Empty ID", "short URL ID cannot be empty")
 			return
 		}
 
-		var originalUrl string
-
-		if h.dbConnection != nil {
-			// fetch from database
-			err := h.dbConnection.QueryRow("SELECT original_url FROM urls WHERE short_url = $1", stringId).Scan(&originalUrl)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					h.logger.Infow("DB fetch", "error", fmt.Sprintf("id: \"%v\" doesn't exists", stringId))
-					jsonutils.WriteJSONError(w, http.StatusBadRequest, "Non existing ID", "provided short URL ID doesn't exists")
-					return
-				} else {
-					h.logger.Errorw("DB fetch", "error", err, "id", stringId)
-					jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
-					return
-				}
+		domain, err := h.requestDomain(r)
+		if err != nil {
+			h.logger.Errorw("Domain lookup", "error", err, "host", r.Host)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+			return
+		}
+		link, err := h.findLink(r.Context(), domain, stringId)
+		if err != nil {
+			if errors.Is(err, errLinkNotFound) {
+				h.logger.Infow("Link fetch", "error", fmt.Sprintf("id: \"%v\" doesn't exists", stringId))
+				jsonutils.WriteJSONError(w, http.StatusBadRequest, "Non existing ID", "provided short URL ID doesn't exists")
+				return
 			}
-		} else {
-			url, exists := h.URLs[stringId]
-			if !exists {
-				w.WriteHeader(http.StatusBadRequest)
+			h.logger.Errorw("Link fetch", "error", err, "id", stringId)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+			return
+		}
+
+		country := h.visitorCountry(r)
+		destination, sticky := h.chooseDestination(r, link, country)
+
+		if !link.Active(time.Now()) {
+			h.serveInactiveLink(w, r, link)
+			return
+		}
+		if link.Exhausted() {
+			h.writeClickError(w, errClicksExhausted, link)
+			return
+		}
+
+		// the destination depends on the device, so shared caches must keep one copy per User-Agent
+		if len(link.PlatformURLs) > 0 {
+			w.Header().Add("Vary", "User-Agent")
+		}
+		if link.Sticky {
+			w.Header().Add("Vary", "Cookie")
+		}
+
+		if link.PasswordHash != "" {
+			h.servePasswordProtected(w, r, link, destination, sticky, country)
+			return
+		}
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if h.serveBlocked(w, link, destination) {
+			return
+		}
+
+		if preview {
+			renderPreviewPage(w, h.linkURL(link), link, destination)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			if err := h.recordClick(r, link, destination, country); err != nil {
+				h.writeClickError(w, err, link)
 				return
-			}	
-			originalUrl = url		
+			}
+			// a visitor is only assigned a destination they are actually sent to
+			if sticky != nil {
+				http.SetCookie(w, sticky)
+			}
 		}
 
-		http.Redirect(w, r, originalUrl, http.StatusTemporaryRedirect)
+		status := h.redirectStatus(link)
+		// a cached redirect would skip the click limit, the rotation or the geo targeting
+		if link.MaxClicks > 0 || len(link.Destinations) > 0 || len(link.CountryURLs) > 0 {
+			status = temporaryRedirect(status)
+		}
+		h.setRedirectCacheHeaders(w, status)
+		http.Redirect(w, r, link.withQuery(destination, r.URL.RawQuery), status)
 	} else {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
 type PostURLBody struct {
-	Url string `json:"url"`
+	Url          string     `json:"url"`
+	RedirectType int        `json:"redirect_type,omitempty"`
+	QR           bool       `json:"qr,omitempty"` // include a QR code in the response
+	Password     string     `json:"password,omitempty"`
+	MaxClicks    int64      `json:"max_clicks,omitempty"`
+	NotBefore    *time.Time `json:"not_before,omitempty"`
+	NotAfter     *time.Time `json:"not_after,omitempty"`
+	// per-platform destinations, keyed by "ios" or "android"
+	PlatformURLs URLOverrides `json:"platform_urls,omitempty"`
+	// per-country destinations, keyed by ISO 3166-1 alpha-2 code
+	CountryURLs URLOverrides `json:"country_urls,omitempty"`
+	// traffic split between several destinations, optionally sticky per visitor
+	Destinations WeightedDestinations `json:"destinations,omitempty"`
+	Sticky       bool                 `json:"sticky,omitempty"`
+	// forward the query string of visits, and UTM parameters added to the destination
+	PassQuery bool      `json:"pass_query,omitempty"`
+	UTM       UTMParams `json:"utm,omitzero"`
+	// one of the registered domains. the domain of the server when empty
+	Domain string `json:"domain,omitempty"`
+	// labels to organize links by
+	Tags   []string `json:"tags,omitempty"`
+	Folder string   `json:"folder,omitempty"`
+	// custom short code, "code" or "namespace/code". random when empty
+	Alias string `json:"alias,omitempty"`
 }
 
 type PostURLResponse struct {
-	Uuid        uuid.UUID `json:"uuid"`
-	ShortUrl    string    `json:"short_url"`
-	OriginalUrl string    `json:"original_url"`
+	Uuid         uuid.UUID `json:"uuid"`
+	ShortUrl     string    `json:"short_url"`
+	OriginalUrl  string    `json:"original_url"`
+	RedirectType int       `json:"redirect_type"`
+	Domain       string    `json:"domain,omitempty"`
+	QRCode       string    `json:"qr_code,omitempty"` // base64 PNG data URI
 }
 
 func (h *Handler) HandlePostRESTApi(w http.ResponseWriter, r *http.Request) {
@@ -156,8 +325,22 @@ func (h *Handler) HandlePostRESTApi(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// API keys are optional here, but a presented key must be valid and allowed to create links
+	key, err := h.authenticate(r.Context(), r)
+	if err != nil && !errors.Is(err, errNoAPIKey) {
+		h.writeAuthError(w, err)
+		return
+	}
+	if key != nil && !key.HasScope(ScopeCreate) {
+		jsonutils.WriteJSONError(w, http.StatusForbidden, "Forbidden", "API key lacks the \""+ScopeCreate+"\" scope")
+		return
+	}
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
+		if middleware.WriteBodyTooLarge(w, err) {
+			return
+		}
 		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Failed to read request body", "failed to read request body")
 		return
 	}
@@ -178,15 +361,104 @@ func (h *Handler) HandlePostRESTApi(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := url.ParseRequestURI(postURLBody.Url); err != nil {
-		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid URL format", "")
+	if err := postURLBody.Validate(); err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	originalUrl, err := h.URLPolicy.Normalize(postURLBody.Url)
+	if err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid URL format", err.Error())
+		return
+	}
+
+	platformURLs, err := h.normalizeOverrides(postURLBody.PlatformURLs)
+	if err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid URL format", err.Error())
+		return
+	}
+
+	countryURLs, err := h.normalizeCountryURLs(postURLBody.CountryURLs)
+	if err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid URL format", err.Error())
+		return
+	}
+
+	destinations, err := h.normalizeDestinations(postURLBody.Destinations)
+	if err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid URL format", err.Error())
 		return
 	}
 
-	encodedUrl := GenerateRandomUrl()
-	h.URLs[encodedUrl] = postURLBody.Url
+	tags, err := normalizeTags(postURLBody.Tags)
+	if err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid tags", err.Error())
+		return
+	}
 
-	result := PostURLResponse{Uuid: uuid.New(), ShortUrl: encodedUrl, OriginalUrl: postURLBody.Url}
+	folder, err := normalizeFolder(postURLBody.Folder)
+	if err != nil {
+		jsonutils.WriteJSONError(w, http.StatusBadRequest, "Invalid folder", err.Error())
+		return
+	}
+
+	domain, err := h.resolveDomain(r.Context(), postURLBody.Domain)
+	if err != nil {
+		h.writeDomainError(w, err)
+		return
+	}
+
+	shortUrl := GenerateRandomUrl()
+	if postURLBody.Alias != "" {
+		if !h.authorizeAlias(w, r, key, postURLBody.Alias) {
+			return
+		}
+		shortUrl = postURLBody.Alias
+	}
+
+	link := &Link{
+		Uuid:         uuid.New(),
+		ShortUrl:     shortUrl,
+		OriginalUrl:  originalUrl,
+		RedirectType: postURLBody.RedirectType,
+		MaxClicks:    postURLBody.MaxClicks,
+		NotBefore:    postURLBody.NotBefore,
+		NotAfter:     postURLBody.NotAfter,
+		PlatformURLs: platformURLs,
+		CountryURLs:  countryURLs,
+		Destinations: destinations,
+		Sticky:       postURLBody.Sticky,
+		PassQuery:    postURLBody.PassQuery,
+		UTM:          postURLBody.UTM,
+		Domain:       domain,
+		Tags:         tags,
+		Folder:       folder,
+	}
+	if postURLBody.Password != "" {
+		if link.PasswordHash, err = hashLinkPassword(postURLBody.Password); err != nil {
+			h.logger.Errorw("Hash link password", "error", err)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal server error", "")
+			return
+		}
+	}
+	if key != nil {
+		link.APIKeyID = key.ID.String()
+		link.Owner = key.Owner
+	}
+
+	if result, ok := h.screenLink(link); !ok {
+		jsonutils.WriteJSONError(w, http.StatusForbidden, "Blocked URL", result.Reason)
+		return
+	}
+
+	result := PostURLResponse{Uuid: link.Uuid, ShortUrl: link.ShortUrl, OriginalUrl: link.OriginalUrl, RedirectType: h.redirectStatus(link), Domain: link.Domain}
+	if postURLBody.QR {
+		if result.QRCode, err = h.qrDataURI(link); err != nil {
+			h.logger.Errorw("QR render", "error", err, "id", link.ShortUrl)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal server error", "")
+			return
+		}
+	}
 
 	jsonResult, err := json.Marshal(&result)
 	if err != nil {
@@ -194,23 +466,14 @@ func (h *Handler) HandlePostRESTApi(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if h.dbConnection != nil {
-		// save to database
-		_, err = h.dbConnection.ExecContext(context.Background(), "INSERT INTO urls (id, original_url, short_url) VALUES ($1, $2, $3)", result.Uuid, result.OriginalUrl, result.ShortUrl)
-		if err != nil {
-			h.logger.Errorw("DB Exec query", "error", err, "values", result)
-			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "cannot save to database")
+	if err := h.saveLink(r.Context(), link); err != nil {
+		if errors.Is(err, errLinkExists) {
+			jsonutils.WriteJSONError(w, http.StatusConflict, "Alias taken", err.Error())
 			return
-		}	
-	} else if h.StoragePath != "" {
-		// save to file
-		message, code, err := saveToFile(jsonResult, h.StoragePath)
-		if err != nil {
-			jsonutils.WriteJSONError(w, code, "Internal Server Error", message)
-			return
-		}	
-	} else {
-		h.URLs[encodedUrl] = postURLBody.Url
+		}
+		h.logger.Errorw("Save link", "error", err, "values", link)
+		jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "cannot save short URL")
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
@@ -241,4 +504,4 @@ func (h *Handler) PingBD(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.WriteHeader(http.StatusOK)
-}
\ No newline at end of file
+}
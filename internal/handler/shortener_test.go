@@ -383,3 +383,59 @@ func TestPostRESTApi_InvalidURL(t *testing.T) {
 		t.Errorf("expected %v status code, got %v", http.StatusBadRequest, res.StatusCode)
 	}
 }
+
+func TestPostRESTApi_DisallowedScheme(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
+
+	sugar := logger.Sugar()
+
+	h := New("http://localhost:8080", make(map[string]string), "", nil, sugar)
+
+	for _, disallowedURL := range []string{"javascript:alert(1)", "ftp://example.com/file"} {
+		bytesPostURLBody, err := json.Marshal(&PostURLBody{Url: disallowedURL})
+		if err != nil {
+			t.Errorf("error on marshal post body: %v", err)
+		}
+
+		r := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(string(bytesPostURLBody)))
+		r.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		h.HandlePostRESTApi(w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %v status code for %q, got %v", http.StatusBadRequest, disallowedURL, w.Code)
+		}
+	}
+}
+
+func TestPostURL_NormalizesURL(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
+
+	sugar := logger.Sugar()
+
+	h := New("http://localhost:8080", make(map[string]string), "", nil, sugar)
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader("HTTPS://YouTube.com:443/watch?v=1&list=2"))
+	w := httptest.NewRecorder()
+	h.HandlePost(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("incorrect status code. Got %v, wanted %v", w.Code, http.StatusCreated)
+	}
+
+	splitted := strings.Split(w.Body.String(), "/")
+	id := splitted[len(splitted)-1]
+
+	if h.URLs[id] != "https://youtube.com/watch?v=1&list=2" {
+		t.Errorf("URL was not normalized. Got %v", h.URLs[id])
+	}
+}
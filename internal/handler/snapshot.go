@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+)
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// reader is where full reads of the database go, the transaction of a
+// snapshot or the connection pool
+func (h *Handler) reader() querier {
+	if h.snapshotTx != nil {
+		return h.snapshotTx
+	}
+	return h.dbConnection
+}
+
+// Snapshot returns a handler whose listings of every record (the ones
+// MigrateTo reads) all see the database as it was when the snapshot was
+// taken, so a copy made from it is consistent. close ends the snapshot.
+// Other backends are returned as they are: the command line loads them into
+// memory on its own, where nothing changes them
+func (h *Handler) Snapshot(ctx context.Context) (snapshot *Handler, close func() error, err error) {
+	if h.dbConnection == nil {
+		return h, func() error { return nil }, nil
+	}
+
+	tx, err := h.dbConnection.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshot = New(h.BaseURL, h.URLs, "", h.dbConnection, h.logger)
+	snapshot.snapshotTx = tx
+	return snapshot, tx.Rollback, nil
+}
+
+// Empty reports whether the storage holds no links, keys, domains or namespaces
+func (h *Handler) Empty(ctx context.Context) (bool, error) {
+	if h.dbConnection != nil {
+		var empty bool
+		err := h.dbConnection.QueryRowContext(ctx, `SELECT NOT (EXISTS (SELECT 1 FROM urls) OR EXISTS (SELECT 1 FROM api_keys)
+			OR EXISTS (SELECT 1 FROM domains) OR EXISTS (SELECT 1 FROM namespaces))`).Scan(&empty)
+		return empty, err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.URLs) == 0 && len(h.apiKeys) == 0 && len(h.domains) == 0 && len(h.namespaces) == 0, nil
+}
@@ -0,0 +1,375 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Link is a stored short link. Its JSON form is also a line of the storage
+// file, so the first three fields must stay compatible with PostURLResponse.
+type Link struct {
+	Uuid         uuid.UUID  `json:"uuid"`
+	ShortUrl     string     `json:"short_url"`
+	OriginalUrl  string     `json:"original_url"`
+	Domain       string     `json:"domain,omitempty"` // empty for the domain of BaseURL
+	APIKeyID     string     `json:"api_key_id,omitempty"`
+	Owner        string     `json:"owner,omitempty"`
+	Flagged      bool       `json:"flagged,omitempty"`
+	FlagReason   string     `json:"flag_reason,omitempty"`
+	RedirectType int        `json:"redirect_type,omitempty"` // zero means the server default
+	CreatedAt    time.Time  `json:"created_at,omitzero"`
+	PasswordHash string     `json:"password_hash,omitempty"` // bcrypt, empty when the link is public
+	MaxClicks    int64      `json:"max_clicks,omitempty"`    // zero means unlimited
+	NotBefore    *time.Time `json:"not_before,omitempty"`
+	NotAfter     *time.Time `json:"not_after,omitempty"`
+
+	// destinations for visitors on a specific platform, OriginalUrl for everyone else
+	PlatformURLs URLOverrides `json:"platform_urls,omitempty"`
+	CountryURLs  URLOverrides `json:"country_urls,omitempty"`
+
+	// weighted destinations a redirect rotates between. Sticky visitors keep theirs
+	Destinations WeightedDestinations `json:"destinations,omitempty"`
+	Sticky       bool                 `json:"sticky,omitempty"`
+
+	// PassQuery forwards the query string of a visit to the destination. UTM
+	// parameters are added at redirect time when the destination lacks them
+	PassQuery bool      `json:"pass_query,omitempty"`
+	UTM       UTMParams `json:"utm,omitzero"`
+
+	// labels to organize links by. Folder is a path like "marketing/2024"
+	Tags   Tags   `json:"tags,omitempty"`
+	Folder string `json:"folder,omitempty"`
+
+	// counted from click records, never stored with the link itself
+	Clicks int64 `json:"-"`
+}
+
+// fileRecord is a typed line of the storage file. Lines without a kind are
+// links, which keeps files written by older versions readable. Link lines are
+// decoded into fileRecord too, so its keys must not clash with those of Link.
+type fileRecord struct {
+	Kind      string     `json:"kind"`
+	APIKey    *APIKey    `json:"api_key,omitempty"`
+	Revision  *Revision  `json:"revision,omitempty"`
+	Click     *Click     `json:"click,omitempty"`
+	Domain    *Domain    `json:"registered_domain,omitempty"`
+	Namespace *Namespace `json:"namespace,omitempty"`
+	Deleted   *linkRef   `json:"deleted_link,omitempty"`
+}
+
+// linkRef names a link by its domain and short code
+type linkRef struct {
+	Domain   string `json:"domain,omitempty"`
+	ShortUrl string `json:"short_url"`
+}
+
+const (
+	recordAPIKey    = "api_key"
+	recordRevision  = "revision"
+	recordClick     = "click"
+	recordDomain    = "domain"
+	recordNamespace = "namespace"
+	recordDeleted   = "link_deleted"
+)
+
+// Exhausted reports whether a click-limited link has used all of its clicks
+func (l *Link) Exhausted() bool {
+	return l.MaxClicks > 0 && l.Clicks >= l.MaxClicks
+}
+
+// Active reports whether now is inside the activation window of the link.
+// The window includes NotBefore and excludes NotAfter
+func (l *Link) Active(now time.Time) bool {
+	if l.NotBefore != nil && now.Before(*l.NotBefore) {
+		return false
+	}
+	return l.NotAfter == nil || now.Before(*l.NotAfter)
+}
+
+var (
+	errLinkNotFound = errors.New("short URL not found")
+	errLinkExists   = errors.New("short URL is already taken")
+)
+
+// Postgres error code of unique constraint violations
+const uniqueViolation = "23505"
+
+// columns of the urls table selected by scanLink, in order
+const linkColumns = "id, original_url, short_url, COALESCE(api_key_id, ''), COALESCE(owner, ''), flagged, COALESCE(flag_reason, ''), redirect_type, created_at, clicks, COALESCE(password_hash, ''), COALESCE(max_clicks, 0), not_before, not_after, platform_urls, country_urls, destinations, sticky, pass_query, COALESCE(utm_source, ''), COALESCE(utm_medium, ''), COALESCE(utm_campaign, ''), domain, tags, COALESCE(folder, '')"
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanLink(row rowScanner) (*Link, error) {
+	var link Link
+	err := row.Scan(&link.Uuid, &link.OriginalUrl, &link.ShortUrl, &link.APIKeyID, &link.Owner, &link.Flagged, &link.FlagReason, &link.RedirectType,
+		&link.CreatedAt, &link.Clicks, &link.PasswordHash, &link.MaxClicks,
+		&link.NotBefore, &link.NotAfter, &link.PlatformURLs, &link.CountryURLs, &link.Destinations, &link.Sticky,
+		&link.PassQuery, &link.UTM.Source, &link.UTM.Medium, &link.UTM.Campaign, &link.Domain,
+		&link.Tags, &link.Folder)
+	if err == sql.ErrNoRows {
+		return nil, errLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// findLink looks a link up by its domain and short ID
+func (h *Handler) findLink(ctx context.Context, domain string, id string) (*Link, error) {
+	if h.dbConnection != nil {
+		return scanLink(h.dbConnection.QueryRowContext(ctx, "SELECT "+linkColumns+" FROM urls WHERE domain = $1 AND short_url = $2", domain, id))
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	key := linkKey(domain, id)
+	originalUrl, exists := h.URLs[key]
+	if !exists {
+		return nil, errLinkNotFound
+	}
+
+	// links added straight to URLs have no metadata
+	link := Link{ShortUrl: id, Domain: domain}
+	if stored := h.links[key]; stored != nil {
+		link = *stored
+	}
+	link.OriginalUrl = originalUrl
+	link.Clicks = h.clickCounts[key]
+	return &link, nil
+}
+
+// listFlaggedLinks returns links marked for review
+func (h *Handler) listFlaggedLinks(ctx context.Context) ([]*Link, error) {
+	if h.dbConnection != nil {
+		rows, err := h.dbConnection.QueryContext(ctx, "SELECT "+linkColumns+" FROM urls WHERE flagged")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		links := make([]*Link, 0)
+		for rows.Next() {
+			link, err := scanLink(rows)
+			if err != nil {
+				return nil, err
+			}
+			links = append(links, link)
+		}
+		return links, rows.Err()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	links := make([]*Link, 0)
+	for _, link := range h.links {
+		if link.Flagged {
+			flagged := *link
+			flagged.OriginalUrl = h.URLs[link.key()]
+			links = append(links, &flagged)
+		}
+	}
+	return links, nil
+}
+
+// saveLink persists a new link to the active storage backend
+func (h *Handler) saveLink(ctx context.Context, link *Link) error {
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now().UTC()
+	}
+
+	if h.dbConnection != nil {
+		return insertLink(ctx, h.dbConnection, link)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := link.key()
+	if _, exists := h.URLs[key]; exists {
+		return errLinkExists
+	}
+	if h.StoragePath != "" {
+		jsonLink, err := json.Marshal(link)
+		if err != nil {
+			return err
+		}
+		if _, _, err := saveToFile(jsonLink, h.StoragePath); err != nil {
+			return err
+		}
+	}
+
+	h.URLs[key] = link.OriginalUrl
+	h.links[key] = link
+	h.search.add(link)
+	return nil
+}
+
+// deleteLink removes a link along with its revisions and clicks. The storage
+// file keeps the old lines and gets a record telling to forget them
+func (h *Handler) deleteLink(ctx context.Context, domain string, id string) error {
+	if h.dbConnection != nil {
+		tx, err := h.dbConnection.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		result, err := tx.ExecContext(ctx, "DELETE FROM urls WHERE domain = $1 AND short_url = $2", domain, id)
+		if err != nil {
+			return err
+		}
+		if deleted, err := result.RowsAffected(); err != nil {
+			return err
+		} else if deleted == 0 {
+			return errLinkNotFound
+		}
+		for _, table := range []string{"url_revisions", "clicks"} {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE domain = $1 AND short_url = $2", domain, id); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := linkKey(domain, id)
+	if _, exists := h.URLs[key]; !exists {
+		return errLinkNotFound
+	}
+	if h.StoragePath != "" {
+		if err := h.appendRecord(fileRecord{Kind: recordDeleted, Deleted: &linkRef{Domain: domain, ShortUrl: id}}); err != nil {
+			return err
+		}
+	}
+	h.forgetLink(key)
+	return nil
+}
+
+// forgetLink drops a link and everything counted for it from memory
+func (h *Handler) forgetLink(key string) {
+	delete(h.URLs, key)
+	delete(h.links, key)
+	delete(h.revisions, key)
+	delete(h.clickCounts, key)
+	delete(h.destinationClicks, key)
+	h.search.remove(key)
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// insertLink adds a link to the urls table. A taken short code is errLinkExists
+func insertLink(ctx context.Context, db execer, link *Link) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO urls (id, original_url, short_url, api_key_id, owner, flagged, flag_reason, redirect_type, created_at, password_hash, max_clicks, not_before, not_after, platform_urls, country_urls, destinations, sticky,
+		pass_query, utm_source, utm_medium, utm_campaign, domain, tags, folder)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, NULLIF($7, ''), $8, $9, NULLIF($10, ''), NULLIF($11, 0), $12, $13, $14, $15, $16, $17,
+		$18, NULLIF($19, ''), NULLIF($20, ''), NULLIF($21, ''), $22, $23, NULLIF($24, ''))`,
+		link.Uuid, link.OriginalUrl, link.ShortUrl, link.APIKeyID, link.Owner, link.Flagged, link.FlagReason, link.RedirectType,
+		link.CreatedAt, link.PasswordHash, link.MaxClicks, link.NotBefore, link.NotAfter, link.PlatformURLs,
+		link.CountryURLs, link.Destinations, link.Sticky, link.PassQuery, link.UTM.Source, link.UTM.Medium, link.UTM.Campaign, link.Domain,
+		link.Tags, link.Folder)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return errLinkExists
+	}
+	return err
+}
+
+// appendRecord writes a typed record to the storage file
+func (h *Handler) appendRecord(record fileRecord) error {
+	jsonRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, _, err = saveToFile(jsonRecord, h.StoragePath)
+	return err
+}
+
+// LoadFromFile replays the storage file into memory. Later lines win, so an
+// updated record simply overrides the previous one.
+func (h *Handler) LoadFromFile() error {
+	file, err := os.OpenFile(h.StoragePath, os.O_RDONLY|os.O_CREATE, 0664)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var record fileRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		switch record.Kind {
+		case "":
+			var link Link
+			if err := json.Unmarshal(line, &link); err != nil {
+				return fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			h.URLs[link.key()] = link.OriginalUrl
+			h.links[link.key()] = &link
+			h.search.add(&link)
+		case recordAPIKey:
+			if record.APIKey != nil {
+				h.apiKeys[record.APIKey.Hash] = record.APIKey
+			}
+		case recordRevision:
+			if record.Revision != nil {
+				key := linkKey(record.Revision.Domain, record.Revision.ShortUrl)
+				h.revisions[key] = append(h.revisions[key], *record.Revision)
+			}
+		case recordClick:
+			if record.Click != nil {
+				h.countClick(record.Click)
+			}
+		case recordDomain:
+			if record.Domain != nil {
+				h.domains[record.Domain.Name] = record.Domain
+			}
+		case recordNamespace:
+			if record.Namespace != nil {
+				h.namespaces[record.Namespace.Name] = record.Namespace
+			}
+		case recordDeleted:
+			if record.Deleted != nil {
+				h.forgetLink(linkKey(record.Deleted.Domain, record.Deleted.ShortUrl))
+			}
+		default:
+			return fmt.Errorf("line %d: unknown record kind %q", lineNumber, record.Kind)
+		}
+	}
+
+	return scanner.Err()
+}
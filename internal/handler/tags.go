@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Tags label a link for filtering. They are stored as a JSON array so Postgres
+// can index them with GIN
+type Tags []string
+
+const (
+	maxTags         = 20
+	maxFolderLength = 128
+)
+
+var (
+	tagPattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}$`)
+	folderPattern = regexp.MustCompile(`^[A-Za-z0-9 _.-]+(/[A-Za-z0-9 _.-]+)*$`)
+)
+
+// Value implements driver.Valuer. No tags are stored as NULL
+func (t Tags) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (t *Tags) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, t)
+	case string:
+		return json.Unmarshal([]byte(src), t)
+	}
+	return fmt.Errorf("cannot scan %T into Tags", src)
+}
+
+// normalizeTags lowercases and deduplicates tags, in sorted order
+func normalizeTags(tags []string) (Tags, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	normalized := make(Tags, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("tag %q must be 1 to 32 lowercase letters, digits, '-', '_' or '.'", tag)
+		}
+		normalized = append(normalized, tag)
+	}
+	slices.Sort(normalized)
+	normalized = slices.Compact(normalized)
+
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("a link can have at most %d tags", maxTags)
+	}
+	return normalized, nil
+}
+
+// normalizeFolder trims the slashes around a folder path like "marketing/2024"
+func normalizeFolder(folder string) (string, error) {
+	folder = strings.Trim(strings.TrimSpace(folder), "/")
+	if folder == "" {
+		return "", nil
+	}
+	if len(folder) > maxFolderLength || !folderPattern.MatchString(folder) {
+		return "", fmt.Errorf("folder must be at most %d characters of letters, digits, ' ', '-', '_' or '.', separated by '/'", maxFolderLength)
+	}
+	return folder, nil
+}
+
+// setLinkLabels updates the tags and the folder of a link in the urls table
+func setLinkLabels(ctx context.Context, db execer, updated *Link) error {
+	result, err := db.ExecContext(ctx, "UPDATE urls SET tags = $3, folder = NULLIF($4, '') WHERE domain = $1 AND short_url = $2",
+		updated.Domain, updated.ShortUrl, updated.Tags, updated.Folder)
+	if err != nil {
+		return err
+	}
+	if changed, err := result.RowsAffected(); err == nil && changed == 0 {
+		return errLinkNotFound
+	}
+	return nil
+}
+
+// updateLinkLabels stores the tags and the folder of an existing link
+func (h *Handler) updateLinkLabels(ctx context.Context, updated *Link) error {
+	if h.dbConnection != nil {
+		return setLinkLabels(ctx, h.dbConnection, updated)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := updated.key()
+	if _, exists := h.URLs[key]; !exists {
+		return errLinkNotFound
+	}
+	if h.StoragePath != "" {
+		jsonLink, err := json.Marshal(updated)
+		if err != nil {
+			return err
+		}
+		if _, _, err := saveToFile(jsonLink, h.StoragePath); err != nil {
+			return err
+		}
+	}
+
+	h.links[key] = updated
+	return nil
+}
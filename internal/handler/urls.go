@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+)
+
+// URLInfoResponse describes a short URL without following it. Owner and
+// Clicks are only shown to the owner and the admin, and so is the destination
+// of a password protected link
+type URLInfoResponse struct {
+	PostURLResponse
+	CreatedAt         *time.Time           `json:"created_at,omitempty"`
+	Flagged           bool                 `json:"flagged,omitempty"`
+	PasswordProtected bool                 `json:"password_protected,omitempty"`
+	MaxClicks         int64                `json:"max_clicks,omitempty"`
+	NotBefore         *time.Time           `json:"not_before,omitempty"`
+	NotAfter          *time.Time           `json:"not_after,omitempty"`
+	PlatformURLs      URLOverrides         `json:"platform_urls,omitempty"`
+	CountryURLs       URLOverrides         `json:"country_urls,omitempty"`
+	Destinations      WeightedDestinations `json:"destinations,omitempty"`
+	Sticky            bool                 `json:"sticky,omitempty"`
+	PassQuery         bool                 `json:"pass_query,omitempty"`
+	UTM               UTMParams            `json:"utm,omitzero"`
+	Tags              Tags                 `json:"tags,omitempty"`
+	Folder            string               `json:"folder,omitempty"`
+	Owner             string               `json:"owner,omitempty"`
+	Clicks            *int64               `json:"clicks,omitempty"`
+	// clicks per served destination of a rotated link
+	DestinationClicks map[string]int64 `json:"destination_clicks,omitempty"`
+}
+
+// writeLinkError writes the response for a failed link lookup
+func (h *Handler) writeLinkError(w http.ResponseWriter, err error, id string) {
+	if errors.Is(err, errLinkNotFound) {
+		jsonutils.WriteJSONError(w, http.StatusNotFound, "Non existing ID", "provided short URL ID doesn't exists")
+		return
+	}
+	h.logger.Errorw("Link fetch", "error", err, "id", id)
+	jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+}
+
+// canReadStats reports whether the request comes from the admin or from the
+// owner of the link with a read-stats key. Unlike authorizeOwner it writes nothing
+func (h *Handler) canReadStats(r *http.Request, link *Link) bool {
+	if h.isAdmin(r) {
+		return true
+	}
+	key, err := h.authenticate(r.Context(), r)
+	if err != nil {
+		return false
+	}
+	return key.HasScope(ScopeReadStats) && link.Owner != "" && key.Owner == link.Owner
+}
+
+func (h *Handler) newURLInfo(r *http.Request, link *Link) (URLInfoResponse, error) {
+	return h.linkInfo(r.Context(), link, h.canReadStats(r, link))
+}
+
+// linkInfo describes a link. Private details are only filled in when private is set
+func (h *Handler) linkInfo(ctx context.Context, link *Link, private bool) (URLInfoResponse, error) {
+	info := URLInfoResponse{
+		PostURLResponse: PostURLResponse{Uuid: link.Uuid, ShortUrl: link.ShortUrl, OriginalUrl: link.OriginalUrl, RedirectType: h.redirectStatus(link),
+			Domain: link.Domain},
+		Flagged:           link.Flagged,
+		PasswordProtected: link.PasswordHash != "",
+		MaxClicks:         link.MaxClicks,
+		NotBefore:         link.NotBefore,
+		NotAfter:          link.NotAfter,
+		PlatformURLs:      link.PlatformURLs,
+		CountryURLs:       link.CountryURLs,
+		Destinations:      link.Destinations,
+		Sticky:            link.Sticky,
+		PassQuery:         link.PassQuery,
+		UTM:               link.UTM,
+		Tags:              link.Tags,
+		Folder:            link.Folder,
+	}
+	if !link.CreatedAt.IsZero() {
+		info.CreatedAt = &link.CreatedAt
+	}
+	if private {
+		clicks := link.Clicks
+		info.Owner = link.Owner
+		info.Clicks = &clicks
+
+		if len(link.Destinations) > 0 {
+			counts, err := h.countClicksByDestination(ctx, link)
+			if err != nil {
+				return info, err
+			}
+			info.DestinationClicks = counts
+		}
+	} else if info.PasswordProtected {
+		info.OriginalUrl = ""
+		info.PlatformURLs = nil
+		info.CountryURLs = nil
+		info.Destinations = nil
+	}
+	return info, nil
+}
+
+// handler for a single short URL of the REST API. GET describes it, PATCH changes
+// its destination and labels, DELETE removes it with its history and clicks
+func (h *Handler) HandleURLById(w http.ResponseWriter, r *http.Request) {
+	h.logger.Infow("HandleURLById called", "path", r.URL.Path, "method", r.Method)
+
+	w.Header().Set("Content-Type", "application/json")
+	id := r.PathValue("id")
+	domain, ok := h.queryDomain(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		link, err := h.findLink(r.Context(), domain, id)
+		if err != nil {
+			h.writeLinkError(w, err, id)
+			return
+		}
+		info, err := h.newURLInfo(r, link)
+		if err != nil {
+			h.logger.Errorw("Count clicks", "error", err, "id", id)
+			jsonutils.WriteJSONError(w, http.StatusInternalServerError, "Internal Server Error", "")
+			return
+		}
+		json.NewEncoder(w).Encode(info)
+	case http.MethodPatch:
+		h.patchURL(w, r, domain, id)
+	case http.MethodDelete:
+		link, err := h.findLink(r.Context(), domain, id)
+		if err != nil {
+			h.writeLinkError(w, err, id)
+			return
+		}
+		if _, ok := h.authorizeOwner(w, r, link, ScopeDelete); !ok {
+			return
+		}
+		if err := h.deleteLink(r.Context(), domain, id); err != nil {
+			h.writeLinkError(w, err, id)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		jsonutils.WriteJSONError(w, http.StatusMethodNotAllowed, "Method not allowed", "method not allowed")
+	}
+}
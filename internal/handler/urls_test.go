@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func getURLInfo(t *testing.T, h *Handler, id string, key string) URLInfoResponse {
+	t.Helper()
+
+	r := httptest.NewRequest("GET", "/api/urls/"+id, nil)
+	r.SetPathValue("id", id)
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	h.HandleURLById(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code on metadata. Got %v, wanted %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var info URLInfoResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("error on decoding metadata: %v", err)
+	}
+	return info
+}
+
+func TestURLInfo_CountsClicksForOwner(t *testing.T) {
+	h := newAdminHandler(t)
+
+	key := createAPIKey(t, h, `{"owner":"print","scopes":["create","read-stats"]}`)
+	id := createOwnedLink(t, h, key.Key, "https://example.com/docs")
+
+	visit(h, id)
+	visit(h, id)
+
+	r := httptest.NewRequest("HEAD", "/"+id, nil)
+	w := httptest.NewRecorder()
+	h.HandleGetById(w, r)
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "https://example.com/docs" {
+		t.Errorf("unexpected HEAD response: %v %q", w.Code, w.Header().Get("Location"))
+	}
+
+	info := getURLInfo(t, h, id, key.Key)
+	if info.OriginalUrl != "https://example.com/docs" || info.Owner != "print" || info.CreatedAt == nil {
+		t.Errorf("unexpected metadata: %+v", info)
+	}
+	if info.Clicks == nil || *info.Clicks != 2 {
+		t.Errorf("expected 2 clicks, got %v", info.Clicks)
+	}
+
+	if public := getURLInfo(t, h, id, ""); public.Owner != "" || public.Clicks != nil {
+		t.Errorf("owner and clicks must not be public: %+v", public)
+	}
+}
+
+func TestURLInfo_ClicksRestoredFromFile(t *testing.T) {
+	storagePath := t.TempDir() + "/storage.json"
+	h := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	h.AdminToken = "admin-secret"
+
+	id := createLink(t, h, `{"url":"https://example.com"}`)
+	visit(h, id)
+
+	reloaded := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	reloaded.AdminToken = "admin-secret"
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading storage file: %v", err)
+	}
+
+	if info := getURLInfo(t, reloaded, id, "admin-secret"); info.Clicks == nil || *info.Clicks != 1 {
+		t.Errorf("expected 1 click after reload, got %v", info.Clicks)
+	}
+}
+
+func TestPreviewPage(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+	id := createLink(t, h, `{"url":"https://example.com/docs"}`)
+
+	w := visit(h, id+"+")
+	if w.Code != http.StatusOK {
+		t.Fatalf("incorrect status code. Got %v, wanted %v", w.Code, http.StatusOK)
+	}
+	if w.Header().Get("Location") != "" {
+		t.Errorf("preview must not redirect")
+	}
+	if !strings.Contains(w.Body.String(), "https://example.com/docs") {
+		t.Errorf("preview does not show the destination: %s", w.Body.String())
+	}
+	if h.clickCounts[id] != 0 {
+		t.Errorf("preview must not count a click")
+	}
+}
+
+func TestExpiredLink(t *testing.T) {
+	h := New("http://localhost:8080", make(map[string]string), "", nil, zap.NewNop().Sugar())
+
+	end := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	id := createLink(t, h, `{"url":"https://example.com","not_after":"`+end.Format(time.RFC3339)+`"}`)
+	if info := getURLInfo(t, h, id, ""); info.NotAfter == nil || !info.NotAfter.Equal(end) {
+		t.Errorf("metadata must report not_after as the expiry, got %v", info.NotAfter)
+	}
+
+	// an expired link answers like any link outside of its activation window
+	expired := time.Now().Add(-time.Minute)
+	h.links[id].NotAfter = &expired
+	if w := visit(h, id); w.Code != http.StatusNotFound {
+		t.Errorf("incorrect status code. Got %v, wanted %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func deleteURL(h *Handler, id string, key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("DELETE", "/api/urls/"+id, nil)
+	r.SetPathValue("id", id)
+	r.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	h.HandleURLById(w, r)
+	return w
+}
+
+func TestDeleteURL_RequiresScope(t *testing.T) {
+	h := newAdminHandler(t)
+
+	key := createAPIKey(t, h, `{"owner":"print","scopes":["create"]}`)
+	id := createOwnedLink(t, h, key.Key, "https://example.com/docs")
+
+	if w := deleteURL(h, id, key.Key); w.Code != http.StatusForbidden {
+		t.Errorf("incorrect status code without the delete scope. Got %v, wanted %v", w.Code, http.StatusForbidden)
+	}
+	if w := visit(h, id); w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("link must survive a forbidden delete, got %v", w.Code)
+	}
+}
+
+func TestDeleteURL_RemovesLink(t *testing.T) {
+	storagePath := t.TempDir() + "/storage.json"
+	h := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	h.AdminToken = "admin-secret"
+
+	key := createAPIKey(t, h, `{"owner":"print","scopes":["create","delete"]}`)
+	other := createAPIKey(t, h, `{"owner":"other","scopes":["create","delete"]}`)
+	id := createOwnedLink(t, h, key.Key, "https://example.com/docs")
+	visit(h, id)
+
+	if w := deleteURL(h, id, other.Key); w.Code != http.StatusForbidden {
+		t.Errorf("incorrect status code for another owner. Got %v, wanted %v", w.Code, http.StatusForbidden)
+	}
+	if w := deleteURL(h, id, key.Key); w.Code != http.StatusNoContent {
+		t.Fatalf("incorrect status code. Got %v, wanted %v: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	if w := visit(h, id); w.Code != http.StatusBadRequest {
+		t.Errorf("deleted link still resolves: %v", w.Code)
+	}
+	if w := deleteURL(h, id, "admin-secret"); w.Code != http.StatusNotFound {
+		t.Errorf("incorrect status code on a second delete. Got %v, wanted %v", w.Code, http.StatusNotFound)
+	}
+
+	reloaded := New("http://localhost:8080", make(map[string]string), storagePath, nil, zap.NewNop().Sugar())
+	if err := reloaded.LoadFromFile(); err != nil {
+		t.Fatalf("error on loading storage file: %v", err)
+	}
+	if _, exists := reloaded.URLs[id]; exists {
+		t.Errorf("deleted link came back after reload")
+	}
+	if reloaded.clickCounts[id] != 0 {
+		t.Errorf("clicks of the deleted link came back after reload")
+	}
+}
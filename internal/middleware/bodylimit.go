@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+)
+
+// WriteBodyTooLarge writes a 413 response if err was caused by a body size
+// limit and reports whether it did
+func WriteBodyTooLarge(w http.ResponseWriter, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+
+	writeTooLarge(w, maxBytesErr.Limit)
+	return true
+}
+
+func writeTooLarge(w http.ResponseWriter, limit int64) {
+	jsonutils.WriteJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large", fmt.Sprintf("request body must not exceed %d bytes", limit))
+}
+
+// BodyLimitMiddleware caps the request body at maxBytes. Placed before
+// CompressMiddleware it limits the compressed body, placed after it limits
+// the decompressed one, which is what protects from decompression bombs
+func BodyLimitMiddleware(h http.Handler, maxBytes int64) http.Handler {
+	return RouteBodyLimitMiddleware(h, maxBytes, nil)
+}
+
+// RouteBodyLimitMiddleware is BodyLimitMiddleware with other limits for some
+// paths, like bulk imports that stream much more than a single link
+func RouteBodyLimitMiddleware(h http.Handler, maxBytes int64, routes map[string]int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := maxBytes
+		if routeLimit, ok := routes[r.URL.Path]; ok {
+			limit = routeLimit
+		}
+
+		// reject early when the client announces a body that is too large
+		if r.ContentLength > limit {
+			writeTooLarge(w, limit)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func limitedStack() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			if WriteBodyTooLarge(w, err) {
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	return BodyLimitMiddleware(CompressMiddleware(BodyLimitMiddleware(h, 1<<20)), 64<<10)
+}
+
+func TestBodyLimit_CompressedBodyTooLarge(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("a", 65<<10)))
+	w := httptest.NewRecorder()
+	limitedStack().ServeHTTP(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %v status code, got %v", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected JSON error body, got Content-Type %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestBodyLimit_DecompressionBomb(t *testing.T) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write(make([]byte, 8<<20))
+	gz.Close()
+
+	if body.Len() >= 64<<10 {
+		t.Fatalf("bomb is too large for the test: %v bytes", body.Len())
+	}
+
+	r := httptest.NewRequest("POST", "/", &body)
+	r.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	limitedStack().ServeHTTP(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %v status code, got %v", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
+
+func TestBodyLimit_WithinLimits(t *testing.T) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte("https://youtube.com"))
+	gz.Close()
+
+	r := httptest.NewRequest("POST", "/", &body)
+	r.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	limitedStack().ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected %v status code, got %v", http.StatusCreated, w.Code)
+	}
+}
+
+func TestBodyLimit_RouteLimit(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			WriteBodyTooLarge(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	limited := RouteBodyLimitMiddleware(h, 64<<10, map[string]int64{"/api/import": 1 << 20})
+
+	for path, want := range map[string]int{"/api/import": http.StatusCreated, "/api/shorten": http.StatusRequestEntityTooLarge} {
+		r := httptest.NewRequest("POST", path, strings.NewReader(strings.Repeat("a", 512<<10)))
+		w := httptest.NewRecorder()
+		limited.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("expected %v status code for %s, got %v", want, path, w.Code)
+		}
+	}
+}
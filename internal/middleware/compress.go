@@ -0,0 +1,333 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/advn1/url-shortener/internal/jsonutils"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// responses smaller than this are sent as is. compression overhead isn't worth it
+const compressMinSize = 1024
+
+// content types worth compressing. everything else (images, archives) usually already is
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/x-ndjson",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// supported content codings, in order of preference when the client rates them equally
+const (
+	encodingBrotli = "br"
+	encodingZstd   = "zstd"
+	encodingGzip   = "gzip"
+)
+
+var allEncodings = []string{encodingBrotli, encodingZstd, encodingGzip}
+
+// encoder is implemented by gzip.Writer, brotli.Writer and zstd.Encoder
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	encodingGzip: {New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}},
+	encodingBrotli: {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, 5)
+	}},
+	encodingZstd: {New: func() any {
+		enc, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+}
+
+// newDecoder wraps a request body compressed with the given coding
+func newDecoder(encoding string, body io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case encodingGzip:
+		return gzip.NewReader(body)
+	case encodingBrotli:
+		return io.NopCloser(brotli.NewReader(body)), nil
+	case encodingZstd:
+		dec, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, errUnsupportedEncoding
+}
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks the best of the supported codings according to the
+// q-values of an Accept-Encoding header. Empty result means identity
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+			q = parsed
+		}
+
+		if coding == "*" {
+			wildcard = q
+		} else {
+			qualities[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range supported {
+		q, listed := qualities[coding]
+		if !listed {
+			q = max(wildcard, 0)
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// CompressWriter decides whether to compress lazily: the body is buffered
+// until it reaches compressMinSize, then headers are sent and the rest is streamed
+type CompressWriter struct {
+	http.ResponseWriter
+	encoding string
+	enc      encoder
+
+	buf         []byte
+	status      int
+	decided     bool
+	wroteHeader bool
+}
+
+func (w *CompressWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader || w.status != 0 {
+		return
+	}
+	w.status = statusCode
+
+	// informational, 204 and 304 responses don't have a body
+	if statusCode < http.StatusOK || statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *CompressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < compressMinSize {
+			return len(b), nil
+		}
+
+		if err := w.flushBuffer(w.shouldCompress()); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends whatever is buffered. Streaming responses are compressed
+// regardless of their size as long as the content type allows it
+func (w *CompressWriter) Flush() {
+	if !w.decided {
+		w.flushBuffer(w.shouldCompress())
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *CompressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close finishes the response. Bodies that never reached compressMinSize are sent uncompressed
+func (w *CompressWriter) Close() error {
+	if !w.decided {
+		if err := w.flushBuffer(false); err != nil {
+			return err
+		}
+	}
+	if w.enc == nil {
+		return nil
+	}
+
+	err := w.enc.Close()
+	w.enc.Reset(io.Discard)
+	encoderPools[w.encoding].Put(w.enc)
+	w.enc = nil
+	return err
+}
+
+func (w *CompressWriter) shouldCompress() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	return isCompressible(header.Get("Content-Type"))
+}
+
+// decide sends the headers, switching to the negotiated coding if compress is set
+func (w *CompressWriter) decide(compress bool) {
+	w.decided = true
+
+	if compress {
+		header := w.Header()
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+
+		w.enc = encoderPools[w.encoding].Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *CompressWriter) flushBuffer(compress bool) error {
+	w.decide(compress)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// decodeRequest replaces a compressed request body with the decoded stream.
+// Codings are listed in the order they were applied, so they're undone in reverse
+func decodeRequest(r *http.Request, supported []string) error {
+	header := r.Header.Get("Content-Encoding")
+	if header == "" {
+		return nil
+	}
+
+	codings := strings.Split(header, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		if coding == "" || coding == "identity" {
+			continue
+		}
+		if !slices.Contains(supported, coding) {
+			return errUnsupportedEncoding
+		}
+
+		body, err := newDecoder(coding, r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = body
+	}
+
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+func compressMiddleware(h http.Handler, supported []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// read compressed requests
+		if err := decodeRequest(r, supported); err != nil {
+			if err == errUnsupportedEncoding {
+				w.Header().Set("Accept-Encoding", strings.Join(supported, ", "))
+				jsonutils.WriteJSONError(w, http.StatusUnsupportedMediaType, "Unsupported Content-Encoding", "supported encodings: "+strings.Join(supported, ", "))
+				return
+			}
+			if WriteBodyTooLarge(w, err) {
+				return
+			}
+			jsonutils.WriteJSONError(w, http.StatusBadRequest, "bad compressed request", "")
+			return
+		}
+		defer r.Body.Close()
+
+		// the response depends on Accept-Encoding whether we compress it or not
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), supported)
+
+		if encoding != "" && r.Method != http.MethodHead {
+			cw := &CompressWriter{ResponseWriter: w, encoding: encoding}
+			defer cw.Close()
+
+			h.ServeHTTP(cw, r)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// CompressMiddleware negotiates br, zstd or gzip for responses and decodes requests compressed with any of them
+func CompressMiddleware(h http.Handler) http.Handler {
+	return compressMiddleware(h, allEncodings)
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br, zstd", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"br;q=0, zstd", "zstd"},
+		{"*", "br"},
+		{"*;q=0.1, gzip;q=0.5", "gzip"},
+		{"br;q=0, zstd;q=0, gzip;q=0", ""},
+		{"identity", ""},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding, allEncodings); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, wanted %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+func TestCompress_EncodesResponses(t *testing.T) {
+	body := strings.Repeat(`{"short_url":"abc"}`, 200)
+	h := CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"br": func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+	}
+
+	for encoding, decode := range decoders {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Header().Get("Content-Encoding") != encoding {
+			t.Fatalf("incorrect Content-Encoding. Got %q, wanted %q", w.Header().Get("Content-Encoding"), encoding)
+		}
+
+		dec, err := decode(w.Body)
+		if err != nil {
+			t.Fatalf("error on creating %s decoder: %v", encoding, err)
+		}
+		data, err := io.ReadAll(dec)
+		if err != nil || string(data) != body {
+			t.Errorf("%s body doesn't round trip: %v", encoding, err)
+		}
+	}
+}
+
+func TestCompress_DecodesRequests(t *testing.T) {
+	var brBody bytes.Buffer
+	bw := brotli.NewWriter(&brBody)
+	bw.Write([]byte("https://youtube.com"))
+	bw.Close()
+
+	zw, _ := zstd.NewWriter(nil)
+	zstdBody := zw.EncodeAll([]byte("https://youtube.com"), nil)
+
+	bodies := map[string][]byte{"br": brBody.Bytes(), "zstd": zstdBody}
+
+	for encoding, body := range bodies {
+		var got string
+		h := CompressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			got = string(data)
+		}))
+
+		r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		r.Header.Set("Content-Encoding", encoding)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+
+		if got != "https://youtube.com" {
+			t.Errorf("%s request body decoded to %q", encoding, got)
+		}
+	}
+}
+
+func TestCompress_UnsupportedRequestEncoding(t *testing.T) {
+	h := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader("data"))
+	r.Header.Set("Content-Encoding", "br")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected %v status code, got %v", http.StatusUnsupportedMediaType, w.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveGzip(h http.HandlerFunc, method string) *http.Response {
+	r := httptest.NewRequest(method, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	GzipMiddleware(h).ServeHTTP(w, r)
+	return w.Result()
+}
+
+func TestGzip_CompressesLargeJSON(t *testing.T) {
+	body := `{"data":"` + strings.Repeat("a", 4096) + `"}`
+	res := serveGzip(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "4107")
+		w.Write([]byte(body))
+	}, "GET")
+	defer res.Body.Close()
+
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip Content-Encoding, got %q", res.Header.Get("Content-Encoding"))
+	}
+	if res.Header.Get("Content-Length") != "" {
+		t.Errorf("Content-Length must be removed from compressed responses")
+	}
+	if res.Header.Get("Vary") != "Accept-Encoding" {
+		t.Errorf("incorrect Vary header. Got %q, wanted Accept-Encoding", res.Header.Get("Vary"))
+	}
+
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatalf("error on reading gzip body: %v", err)
+	}
+	data, _ := io.ReadAll(gz)
+	if string(data) != body {
+		t.Errorf("decompressed body doesn't match")
+	}
+}
+
+func TestGzip_SkipsSmallBodies(t *testing.T) {
+	res := serveGzip(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("http://localhost:8080/abc"))
+	}, "POST")
+	defer res.Body.Close()
+
+	if res.Header.Get("Content-Encoding") != "" {
+		t.Errorf("small body must not be compressed")
+	}
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("incorrect status code. Got %v, wanted %v", res.StatusCode, http.StatusCreated)
+	}
+	data, _ := io.ReadAll(res.Body)
+	if string(data) != "http://localhost:8080/abc" {
+		t.Errorf("incorrect body %q", data)
+	}
+}
+
+func TestGzip_SkipsIncompressibleAndEmpty(t *testing.T) {
+	png := serveGzip(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(make([]byte, 4096))
+	}, "GET")
+	defer png.Body.Close()
+
+	if png.Header.Get("Content-Encoding") != "" {
+		t.Errorf("image/png must not be compressed")
+	}
+
+	redirect := serveGzip(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://google.com")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}, "GET")
+	defer redirect.Body.Close()
+
+	if redirect.Header.Get("Content-Encoding") != "" {
+		t.Errorf("empty redirect must not be compressed")
+	}
+	if redirect.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("incorrect status code. Got %v, wanted %v", redirect.StatusCode, http.StatusTemporaryRedirect)
+	}
+}
@@ -33,6 +33,18 @@ func (r *StatusRecorder) WriteHeader(statusCode int) {
 	r.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Flush passes flushes of streaming responses on to the wrapped writer
+func (r *StatusRecorder) Flush() {
+	if r.responseData.StatusCode == 0 {
+		r.responseData.StatusCode = http.StatusOK
+	}
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func LoggingMiddleware(h http.Handler, sugar *zap.SugaredLogger) http.Handler {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
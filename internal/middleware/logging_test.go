@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestLogging_Flush(t *testing.T) {
+	h := LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flush through the logging middleware failed: %v", err)
+		}
+	}), zap.NewNop().Sugar())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if !w.Flushed {
+		t.Errorf("response was not flushed")
+	}
+}
@@ -0,0 +1,140 @@
+// Package qr renders QR codes as PNG or SVG with a configurable size,
+// error correction level and quiet zone.
+package qr
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+
+	DefaultSize   = 256
+	MinSize       = 64
+	MaxSize       = 2048
+	DefaultMargin = 4
+	MaxMargin     = 16
+)
+
+// Options controls how a code is rendered. Size is the width and height of the
+// image in pixels, Margin is the quiet zone around the code in modules
+type Options struct {
+	Size   int
+	Level  qrcode.RecoveryLevel
+	Margin int
+}
+
+// DefaultOptions returns medium error correction and the standard 4 module quiet zone
+func DefaultOptions() Options {
+	return Options{Size: DefaultSize, Level: qrcode.Medium, Margin: DefaultMargin}
+}
+
+// ParseLevel parses an error correction level: L, M, Q or H
+func ParseLevel(level string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToUpper(level) {
+	case "L":
+		return qrcode.Low, nil
+	case "M":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	}
+	return 0, fmt.Errorf("level must be one of L, M, Q, H")
+}
+
+func (o Options) validate() error {
+	if o.Size < MinSize || o.Size > MaxSize {
+		return fmt.Errorf("size must be between %d and %d", MinSize, MaxSize)
+	}
+	if o.Margin < 0 || o.Margin > MaxMargin {
+		return fmt.Errorf("margin must be between 0 and %d", MaxMargin)
+	}
+	return nil
+}
+
+// Code is an encoded QR code including its quiet zone
+type Code struct {
+	modules [][]bool
+	size    int
+}
+
+// Encode encodes content. It fails if the options are out of range or the
+// content does not fit in a QR code at the requested level
+func Encode(content string, opts Options) (*Code, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	q, err := qrcode.New(content, opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	q.DisableBorder = true
+	bitmap := q.Bitmap()
+
+	modules := make([][]bool, len(bitmap)+2*opts.Margin)
+	for y := range modules {
+		modules[y] = make([]bool, len(modules))
+	}
+	for y, row := range bitmap {
+		copy(modules[y+opts.Margin][opts.Margin:], row)
+	}
+
+	// never draw a module smaller than a pixel
+	size := max(opts.Size, len(modules))
+	return &Code{modules: modules, size: size}, nil
+}
+
+// WritePNG writes the code as a black on white PNG
+func (c *Code) WritePNG(w io.Writer) error {
+	img := image.NewPaletted(image.Rect(0, 0, c.size, c.size), color.Palette{color.White, color.Black})
+
+	count := len(c.modules)
+	for y := 0; y < c.size; y++ {
+		row := c.modules[y*count/c.size]
+		for x := 0; x < c.size; x++ {
+			if row[x*count/c.size] {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// WriteSVG writes the code as an SVG scaled to the requested size. Each row
+// of dark modules is drawn as horizontal runs to keep the document small
+func (c *Code) WriteSVG(w io.Writer) error {
+	count := len(c.modules)
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n", c.size, c.size, count, count)
+	fmt.Fprintf(out, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", count, count)
+	out.WriteString(`<path fill="#000" d="`)
+	for y, row := range c.modules {
+		for x := 0; x < count; {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < count && row[x] {
+				x++
+			}
+			fmt.Fprintf(out, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	out.WriteString("\"/>\n</svg>\n")
+	return out.Flush()
+}
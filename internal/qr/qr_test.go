@@ -0,0 +1,65 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestEncode_PNGSizeAndMargin(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Size = 300
+
+	code, err := Encode("http://localhost:8080/e1ef4c662c790d8e4f72", opts)
+	if err != nil {
+		t.Fatalf("error on encoding: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := code.WritePNG(&buf); err != nil {
+		t.Fatalf("error on writing PNG: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("error on decoding PNG: %v", err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != 300 || bounds.Dy() != 300 {
+		t.Errorf("incorrect image size %v", bounds)
+	}
+
+	// the quiet zone is white and the finder pattern right after it is black
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Errorf("margin is not white")
+	}
+	modulePx := 300 / len(code.modules)
+	if r, _, _, _ := img.At(opts.Margin*modulePx+modulePx/2+1, opts.Margin*modulePx+modulePx/2+1).RGBA(); r != 0 {
+		t.Errorf("finder pattern is not black")
+	}
+}
+
+func TestEncode_SVG(t *testing.T) {
+	code, err := Encode("http://localhost:8080/abc", Options{Size: 128, Margin: 0})
+	if err != nil {
+		t.Fatalf("error on encoding: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := code.WriteSVG(&buf); err != nil {
+		t.Fatalf("error on writing SVG: %v", err)
+	}
+	if !strings.Contains(buf.String(), `width="128"`) || !strings.Contains(buf.String(), "M0 0h7") {
+		t.Errorf("unexpected SVG: %s", buf.String())
+	}
+}
+
+func TestEncode_InvalidOptions(t *testing.T) {
+	for _, opts := range []Options{{Size: 10, Margin: 4}, {Size: 256, Margin: -1}, {Size: MaxSize + 1}} {
+		if _, err := Encode("abc", opts); err == nil {
+			t.Errorf("expected error for %+v", opts)
+		}
+	}
+	if _, err := ParseLevel("X"); err == nil {
+		t.Errorf("expected error for unknown level")
+	}
+}
@@ -0,0 +1,229 @@
+// Package screening checks link destinations against a local blocklist of
+// domains and networks and against destinations that are never allowed.
+package screening
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Verdict int
+
+const (
+	Allow Verdict = iota
+	// Flag allows the link but marks it for review
+	Flag
+	Block
+)
+
+type Result struct {
+	Verdict Verdict
+	Reason  string
+}
+
+// Screener holds the blocklist. It is safe for concurrent use and can be
+// reloaded while serving. A nil Screener has an empty blocklist
+type Screener struct {
+	path string
+
+	mu       sync.RWMutex
+	domains  map[string]struct{}
+	prefixes []netip.Prefix
+	modTime  time.Time
+}
+
+// New creates a screener with the blocklist from path. Empty path means no blocklist
+func New(path string) (*Screener, error) {
+	s := &Screener{path: path, domains: make(map[string]struct{})}
+	if path == "" {
+		return s, nil
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the blocklist file again. The file has one entry per line:
+// a domain (which blocks its subdomains too), an IP address or a CIDR.
+// Empty lines and lines starting with # are ignored
+func (s *Screener) Reload() error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	domains := make(map[string]struct{})
+	prefixes := make([]netip.Prefix, 0)
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(line); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(line); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		if strings.ContainsAny(line, " /:") {
+			return fmt.Errorf("blocklist line %d: invalid entry %q", lineNumber, line)
+		}
+		domains[strings.Trim(strings.ToLower(line), ".")] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.domains = domains
+	s.prefixes = prefixes
+	s.modTime = info.ModTime()
+	s.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the blocklist whenever the file changes, checking every
+// interval until ctx is done. Reload errors keep the previous list
+func (s *Screener) Watch(ctx context.Context, interval time.Duration, onReload func(error)) {
+	if s.path == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				onReload(err)
+				continue
+			}
+
+			s.mu.RLock()
+			changed := !info.ModTime().Equal(s.modTime)
+			s.mu.RUnlock()
+
+			if changed {
+				onReload(s.Reload())
+			}
+		}
+	}
+}
+
+// Check screens a destination URL. The URL is expected to be normalized already
+func (s *Screener) Check(rawURL string) Result {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Result{Verdict: Block, Reason: "malformed URL"}
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap()
+		if s.blockedAddr(addr) {
+			return Result{Verdict: Block, Reason: "destination address is blocklisted"}
+		}
+		if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+			return Result{Verdict: Block, Reason: "private or loopback destinations are not allowed"}
+		}
+		return Result{Verdict: Flag, Reason: "destination is a raw IP address"}
+	}
+	// the validator turns 2130706433, 0x7f.1 or 127.1 into dotted quads, so
+	// such a host here comes from an older link and can't be told from a loopback
+	if labels := strings.Split(host, "."); numericLabel(labels[len(labels)-1]) {
+		return Result{Verdict: Block, Reason: "destination is an IPv4 address in a legacy form"}
+	}
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") || strings.HasSuffix(host, ".internal") {
+		return Result{Verdict: Block, Reason: "private or loopback destinations are not allowed"}
+	}
+
+	if domain, blocked := s.blockedDomain(host); blocked {
+		return Result{Verdict: Block, Reason: "domain " + domain + " is blocklisted"}
+	}
+
+	// credentials in the URL are a common trick to make https://bank.com@evil.com look legit
+	if u.User != nil {
+		return Result{Verdict: Flag, Reason: "URL contains credentials"}
+	}
+	for _, label := range strings.Split(host, ".") {
+		if strings.HasPrefix(label, "xn--") {
+			return Result{Verdict: Flag, Reason: "internationalized domain name"}
+		}
+	}
+
+	return Result{Verdict: Allow}
+}
+
+// numericLabel reports whether a host label is a decimal or 0x hex number,
+// which makes resolvers read the whole host as an IPv4 address
+func numericLabel(label string) bool {
+	digits, hex := strings.CutPrefix(label, "0x")
+	if !hex && digits == "" {
+		return false
+	}
+	return !strings.ContainsFunc(digits, func(r rune) bool {
+		return (r < '0' || r > '9') && (!hex || r < 'a' || r > 'f')
+	})
+}
+
+func (s *Screener) blockedAddr(addr netip.Addr) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, prefix := range s.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedDomain checks the host and all of its parent domains
+func (s *Screener) blockedDomain(host string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for domain := host; domain != ""; {
+		if _, exists := s.domains[domain]; exists {
+			return domain, true
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+	return "", false
+}
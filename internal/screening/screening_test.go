@@ -0,0 +1,85 @@
+package screening
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeBlocklist(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0664); err != nil {
+		t.Fatalf("error on writing blocklist: %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeBlocklist(t, path, "# phishing\nevil.com\n203.0.113.0/24\n198.51.100.7\n")
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("error on loading blocklist: %v", err)
+	}
+
+	tests := []struct {
+		url  string
+		want Verdict
+	}{
+		{"https://youtube.com", Allow},
+		{"https://evil.com/login", Block},
+		{"https://login.evil.com", Block},
+		{"https://notevil.com", Allow},
+		{"http://203.0.113.15/", Block},
+		{"http://198.51.100.7/", Block},
+		{"http://127.0.0.1:8080/", Block},
+		{"http://10.0.0.1/", Block},
+		{"http://[::1]/", Block},
+		{"http://localhost/", Block},
+		{"http://169.254.169.254/latest/meta-data", Block},
+		{"http://2130706433/", Block},
+		{"http://0x7f.1/", Block},
+		{"http://127.1/", Block},
+		{"https://1password.com/", Allow},
+		{"http://8.8.8.8/", Flag},
+		{"https://bank.com@example.com/", Flag},
+		{"https://xn--pypal-4ve.com/", Flag},
+	}
+
+	for _, tt := range tests {
+		if got := s.Check(tt.url); got.Verdict != tt.want {
+			t.Errorf("Check(%q) = %v (%s), wanted %v", tt.url, got.Verdict, got.Reason, tt.want)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeBlocklist(t, path, "evil.com\n")
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("error on loading blocklist: %v", err)
+	}
+	if s.Check("https://phish.example").Verdict != Allow {
+		t.Fatalf("phish.example must not be blocked yet")
+	}
+
+	writeBlocklist(t, path, "evil.com\nphish.example\n")
+
+	if err := s.Reload(); err != nil {
+		t.Fatalf("error on reloading blocklist: %v", err)
+	}
+	if s.Check("https://phish.example").Verdict != Block {
+		t.Errorf("phish.example must be blocked after reload")
+	}
+}
+
+func TestNew_InvalidEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeBlocklist(t, path, "evil.com/path\n")
+
+	if _, err := New(path); err == nil {
+		t.Errorf("expected an error for invalid entry")
+	}
+}
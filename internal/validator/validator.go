@@ -0,0 +1,235 @@
+// Package validator checks and normalizes destination URLs, so every create
+// endpoint accepts the same URLs and equal URLs are stored the same way.
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	ErrEmpty   = errors.New("URL cannot be empty")
+	ErrInvalid = errors.New("URL is malformed")
+	ErrNoHost  = errors.New("URL must contain a host")
+)
+
+// default ports that are dropped during normalization
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+}
+
+type Policy struct {
+	// schemes a URL may use
+	AllowedSchemes []string
+	// max length of the URL after normalization. zero means unlimited
+	MaxLength int
+	// drop everything after # from stored URLs
+	StripFragment bool
+	// sort query parameters by name so equal URLs compare equal. off by
+	// default, as some destinations, like signed URLs, depend on the order
+	SortQuery bool
+}
+
+func DefaultPolicy() *Policy {
+	return &Policy{
+		AllowedSchemes: []string{"http", "https"},
+		MaxLength:      2048,
+		StripFragment:  false,
+		SortQuery:      false,
+	}
+}
+
+// Normalize validates rawURL against the policy and returns its normalized form:
+// lowercase scheme and host, punycode host, no default port and, depending on
+// the policy, sorted query and no fragment
+func (p *Policy) Normalize(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", ErrEmpty
+	}
+
+	if strings.ContainsFunc(rawURL, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) {
+		return "", fmt.Errorf("%w: contains whitespace or control characters", ErrInvalid)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", ErrInvalid
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme == "" {
+		return "", fmt.Errorf("%w: scheme is missing", ErrInvalid)
+	}
+	if !slices.ContainsFunc(p.AllowedSchemes, func(scheme string) bool { return strings.EqualFold(scheme, u.Scheme) }) {
+		return "", fmt.Errorf("scheme %q is not allowed. allowed schemes: %s", u.Scheme, strings.Join(p.AllowedSchemes, ", "))
+	}
+
+	if u.Opaque != "" || u.Host == "" {
+		return "", ErrNoHost
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	if p.SortQuery && u.RawQuery != "" {
+		u.RawQuery = sortQuery(u.RawQuery)
+	}
+	u.ForceQuery = false
+
+	if p.StripFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	normalized := u.String()
+	if p.MaxLength > 0 && len(normalized) > p.MaxLength {
+		return "", fmt.Errorf("URL must not be longer than %d characters", p.MaxLength)
+	}
+	return normalized, nil
+}
+
+// NormalizeDomain validates a bare domain name, such as one links are served
+// from, and returns it lowercase in punycode
+func NormalizeDomain(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", ErrNoHost
+	}
+	if strings.ContainsAny(name, "/:@?#[] ") {
+		return "", fmt.Errorf("%w: domain %q must not contain a scheme, port or path", ErrInvalid, name)
+	}
+	host, err := normalizeHost(name)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return "", fmt.Errorf("%w: domain %q is an IP address", ErrInvalid, name)
+	}
+	if !strings.Contains(host, ".") {
+		return "", fmt.Errorf("%w: domain %q must have at least two labels", ErrInvalid, name)
+	}
+	return host, nil
+}
+
+// normalizeHost lowercases the host and converts internationalized names to
+// punycode. Legacy IPv4 spellings like 2130706433, 0x7f.1 or 127.1, which
+// browsers and resolvers accept, become dotted quads
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", ErrNoHost
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	host = strings.TrimSuffix(host, ".")
+	if ip, ok, err := parseLegacyIPv4(host); ok {
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid IPv4 host %q", ErrInvalid, host)
+		}
+		return ip.String(), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid host %q", ErrInvalid, host)
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// parseLegacyIPv4 parses host the way inet_aton and the URL standard do: one
+// to four parts, each decimal, octal with a leading 0 or hex with 0x, the last
+// one filling the remaining bytes. ok is false when host is a domain name,
+// that is when its last label isn't a number
+func parseLegacyIPv4(host string) (ip net.IP, ok bool, err error) {
+	parts := strings.Split(host, ".")
+	if !looksNumeric(parts[len(parts)-1]) {
+		return nil, false, nil
+	}
+	if len(parts) > 4 {
+		return nil, true, errors.New("more than four parts")
+	}
+
+	var address uint64
+	for i, part := range parts {
+		n, err := parseIPv4Number(part)
+		if err != nil {
+			return nil, true, err
+		}
+		if i < len(parts)-1 {
+			if n > 0xff {
+				return nil, true, errors.New("part out of range")
+			}
+			address |= n << (8 * (3 - i))
+			continue
+		}
+		if n >= 1<<(8*(4-i)) {
+			return nil, true, errors.New("part out of range")
+		}
+		address |= n
+	}
+	return net.IPv4(byte(address>>24), byte(address>>16), byte(address>>8), byte(address)), true, nil
+}
+
+// looksNumeric reports whether a label is made of decimal digits, or of hex
+// digits after 0x, even when it is out of range as a number
+func looksNumeric(label string) bool {
+	isDigit := func(r rune) bool { return r >= '0' && r <= '9' }
+	if hex, found := strings.CutPrefix(strings.ToLower(label), "0x"); found {
+		return !strings.ContainsFunc(hex, func(r rune) bool { return !isDigit(r) && (r < 'a' || r > 'f') })
+	}
+	return label != "" && !strings.ContainsFunc(label, func(r rune) bool { return !isDigit(r) })
+}
+
+func parseIPv4Number(part string) (uint64, error) {
+	base := 10
+	digits := part
+	switch {
+	case strings.HasPrefix(part, "0x") || strings.HasPrefix(part, "0X"):
+		base, digits = 16, part[2:]
+		if digits == "" {
+			return 0, nil
+		}
+	case len(part) > 1 && part[0] == '0':
+		base, digits = 8, part[1:]
+	}
+	return strconv.ParseUint(digits, base, 32)
+}
+
+// sortQuery orders query parameters by name. Unlike url.Values.Encode it keeps
+// the original escaping and the order of repeated parameters
+func sortQuery(rawQuery string) string {
+	params := strings.Split(rawQuery, "&")
+	slices.SortStableFunc(params, func(a, b string) int {
+		nameA, _, _ := strings.Cut(a, "=")
+		nameB, _, _ := strings.Cut(b, "=")
+		return strings.Compare(nameA, nameB)
+	})
+	params = slices.DeleteFunc(params, func(p string) bool { return p == "" })
+	return strings.Join(params, "&")
+}
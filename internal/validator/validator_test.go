@@ -0,0 +1,104 @@
+package validator
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	policy := DefaultPolicy()
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"https://youtube.com", "https://youtube.com"},
+		{"  HTTPS://YouTube.COM/Watch?v=1  ", "https://youtube.com/Watch?v=1"},
+		{"http://example.com:80/a", "http://example.com/a"},
+		{"https://example.com:443", "https://example.com"},
+		{"https://example.com:8443/", "https://example.com:8443/"},
+		{"https://example.com/?b=2&a=1&b=1", "https://example.com/?b=2&a=1&b=1"},
+		{"https://example.com/page#section", "https://example.com/page#section"},
+		{"https://bücher.example/", "https://xn--bcher-kva.example/"},
+		{"https://example.com./", "https://example.com/"},
+		{"http://[::1]:80/", "http://[::1]/"},
+		{"http://2130706433/", "http://127.0.0.1/"},
+		{"http://0x7f.1/", "http://127.0.0.1/"},
+		{"http://127.1/", "http://127.0.0.1/"},
+		{"http://0177.0.0.01:8080/", "http://127.0.0.1:8080/"},
+		{"http://0xA9.254.43518/", "http://169.254.169.254/"},
+		{"http://10.0.0.1./", "http://10.0.0.1/"},
+	}
+
+	for _, tt := range tests {
+		got, err := policy.Normalize(tt.raw)
+		if err != nil {
+			t.Errorf("Normalize(%q) returned error: %v", tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Normalize(%q) = %q, wanted %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize_StripFragment(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.StripFragment = true
+
+	got, err := policy.Normalize("https://example.com/page#section")
+	if err != nil || got != "https://example.com/page" {
+		t.Errorf("expected fragment to be stripped, got %q (%v)", got, err)
+	}
+}
+
+func TestNormalize_SortQuery(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.SortQuery = true
+
+	got, err := policy.Normalize("https://example.com/?b=2&a=1&b=1")
+	if err != nil || got != "https://example.com/?a=1&b=2&b=1" {
+		t.Errorf("expected query to be sorted, got %q (%v)", got, err)
+	}
+}
+
+func TestNormalize_Rejects(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.MaxLength = 40
+
+	rejected := []string{
+		"",
+		"javascript:alert(1)",
+		"ftp://example.com",
+		"://youtube.com",
+		"youtube.com",
+		"https://",
+		"https:///path",
+		"https://exa mple.com",
+		"http://256.0.0.1/",
+		"http://1.2.3.4.5/",
+		"http://127.0.0.08/",
+		"http://4294967296/",
+		"https://example.com/" + "very-long-path-that-exceeds-the-limit",
+	}
+
+	for _, raw := range rejected {
+		if got, err := policy.Normalize(raw); err == nil {
+			t.Errorf("Normalize(%q) = %q, wanted an error", raw, got)
+		}
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	if got, err := NormalizeDomain(" Go.Brand-A.com. "); err != nil || got != "go.brand-a.com" {
+		t.Errorf("NormalizeDomain = %q (%v), wanted %q", got, err, "go.brand-a.com")
+	}
+	if got, err := NormalizeDomain("bücher.example"); err != nil || got != "xn--bcher-kva.example" {
+		t.Errorf("NormalizeDomain = %q (%v), wanted punycode", got, err)
+	}
+
+	for _, raw := range []string{"", "localhost", "https://brand.link", "brand.link:8080", "brand.link/path", "10.0.0.1", "10.1"} {
+		if got, err := NormalizeDomain(raw); err == nil {
+			t.Errorf("NormalizeDomain(%q) = %q, wanted an error", raw, got)
+		}
+	}
+}